@@ -0,0 +1,60 @@
+package prometheus
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+type BatchStats struct {
+	Batches *prometheus.CounterVec // The total number of batches processed by FindInBatches, per name.
+	Rows    *prometheus.CounterVec // The total number of rows processed by FindInBatches, per name.
+}
+
+func newBatchStats(labels map[string]string, config *Config) *BatchStats {
+	labelNames := config.transformLabelNames([]string{"name"})
+	return &BatchStats{
+		Batches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        config.metricName("", "find_in_batches_batches_total"),
+			Help:        config.help(config.metricName("", "find_in_batches_batches_total"), "The total number of batches processed by FindInBatches, per name."),
+			ConstLabels: labels,
+		}, labelNames),
+		Rows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        config.metricName("", "find_in_batches_rows_total"),
+			Help:        config.help(config.metricName("", "find_in_batches_rows_total"), "The total number of rows processed by FindInBatches, per name."),
+			ConstLabels: labels,
+		}, labelNames),
+	}
+}
+
+// get collectors in batch stats
+func (stats *BatchStats) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{stats.Batches, stats.Rows}
+}
+
+// FindInBatches runs db.FindInBatches and counts the batches and rows processed under name, so the progress
+// of long scans can be followed, e.g.
+//
+//	p.FindInBatches(db.Where("processed = ?", false), "export_users", &users, 1000, func(tx *gorm.DB, batch int) error {
+//		return export(users)
+//	})
+//
+// name is a label value, it must identify the job rather than a run.
+func (p *Prometheus) FindInBatches(db *gorm.DB, name string, dest interface{}, batchSize int, fc func(tx *gorm.DB, batch int) error) *gorm.DB {
+	p.batchOnce.Do(func() {
+		p.BatchStats = newBatchStats(p.Labels, p.Config)
+		p.register(p.BatchStats.Collectors()...)
+	})
+
+	labels := prometheus.Labels(p.Config.transformLabels(map[string]string{"name": name}))
+	batches, rows := p.BatchStats.Batches.With(labels), p.BatchStats.Rows.With(labels)
+
+	return db.FindInBatches(dest, batchSize, func(tx *gorm.DB, batch int) error {
+		if err := fc(tx, batch); err != nil {
+			return err
+		}
+
+		batches.Inc()
+		rows.Add(float64(tx.RowsAffected))
+		return nil
+	})
+}
@@ -0,0 +1,858 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	startedAtKey    = "gorm:prometheus:started_at"
+	sqlStartedAtKey = "gorm:prometheus:sql_started_at"
+	sqlDurationKey  = "gorm:prometheus:sql_duration"
+	poolKey         = "gorm:prometheus:pool"
+
+	// settings of gorm.io/plugin/dbresolver forcing the pool of an operation
+	dbResolverWrite = "gorm:db_resolver:write"
+	dbResolverRead  = "gorm:db_resolver:read"
+)
+
+var (
+	defaultRowsBuckets       = prometheus.ExponentialBuckets(1, 4, 8)         // 1 to 16384 rows
+	defaultDurationBuckets   = prometheus.ExponentialBuckets(0.0001, 2.5, 14) // 0.1ms to 15s, primary key lookups take well under 5ms
+	defaultSummaryObjectives = map[float64]float64{0.5: 0.05, 0.95: 0.01, 0.99: 0.001}
+)
+
+type QueryStats struct {
+	Queries     *prometheus.CounterVec   // The total number of operations executed.
+	Duration    prometheus.ObserverVec   // Time spent in operations including hooks, in seconds, a histogram or a summary.
+	SQLDuration *prometheus.HistogramVec // Time spent executing SQL excluding hooks, in seconds.
+	Errors      *prometheus.CounterVec   // The total number of operations failed, excluding record not found and cancellations.
+	Cancelled   *prometheus.CounterVec   // The total number of operations cancelled or timed out by their context.
+	Deadlocks   *prometheus.CounterVec   // The total number of operations failed with a deadlock, also counted in Errors.
+	LockWaits   *prometheus.CounterVec   // The total number of operations failed with a lock wait timeout, also counted in Errors.
+	InFlight    prometheus.Gauge         // The number of operations currently executing, including those waiting for a connection.
+	Rows        *prometheus.HistogramVec // The number of rows returned or affected per operation.
+	BatchSize   prometheus.Histogram     // The number of rows per create statement, e.g. per batch of CreateInBatches.
+	Deletes     *prometheus.CounterVec   // The total number of delete operations succeeded, by kind, soft or hard.
+	Upserts     *prometheus.CounterVec   // The total number of creates with an ON CONFLICT clause succeeded, by result.
+	NoWhere     *prometheus.CounterVec   // The total number of updates and deletes without a WHERE clause, by operation.
+	Preloads    *prometheus.CounterVec   // The total number of queries preloading an association, by association.
+	Joins       *prometheus.CounterVec   // The total number of queries joining an association, by association.
+	Overflows   *prometheus.CounterVec   // The total number of label sets collapsed into overflow by MaxLabelSets, by metric.
+	Savepoints  *prometheus.CounterVec   // The total number of savepoints of nested transactions, by outcome, with TransactionMetrics.
+	PlanCost    prometheus.Histogram     // Estimated cost of the plan of the queries sampled by ExplainSampleRate.
+	SlowQueries *prometheus.CounterVec   // The total number of operations slower than the SlowThreshold of the GORM logger, with SlowQueryMetrics.
+	// Time spent in the operations sampled by CallerSampleRate, by operation and caller, in seconds.
+	CallerDuration *prometheus.HistogramVec
+
+	// Prepared statement cache, only when gorm PrepareStmt is enabled
+	PreparedStmtHits   prometheus.Counter   // The total number of queries executed with a cached prepared statement.
+	PreparedStmtMisses prometheus.Counter   // The total number of queries that prepared a new statement.
+	PreparedStmts      *prometheus.GaugeVec // The number of statements in the prepared statement cache, by table.
+
+	config     *Config
+	tables     map[string]bool // tables labeled in query metrics
+	operations []string        // operations whose callbacks are registered
+
+	limiters map[string]*labelSetLimiter // by metric name, MaxLabelSets and LabelSetLimits
+
+	explaining int32 // 1 while a sampled EXPLAIN runs
+
+	reuse *connReuse // counts the operations with ConnReuseRatio
+
+	stmtTablesLock sync.Mutex
+	stmtTables     map[string]string // tables of the prepared statements, by query
+
+	slowThreshold time.Duration // SlowThreshold of the GORM logger, read when the callbacks are registered
+
+	callersLock sync.Mutex
+	callers     map[string]bool // callers labeled by CallerDuration, at most MaxCallers
+
+	originsLock sync.RWMutex
+	origins     map[string]bool // origins labeled in query metrics, at most MaxOrigins
+
+	rowsTablesLock sync.RWMutex
+	rowsTables     map[string]bool // tables labeled in the rows histogram, at most MaxTables
+}
+
+func newQueryStats(labels map[string]string, config *Config, preparedStmt bool) *QueryStats {
+	labelNames := []string{"operation"}
+	if config.PoolLabel {
+		labelNames = append(labelNames, "pool")
+	}
+	if len(config.TableLabels) > 0 {
+		labelNames = append(labelNames, "table")
+	}
+	if config.StatementLabel {
+		labelNames = append(labelNames, "statement")
+	}
+	if config.OriginFromContext != nil {
+		labelNames = append(labelNames, "origin")
+	}
+	if config.ExecutionLabel {
+		labelNames = append(labelNames, "execution")
+	}
+
+	// with DBNameFunc db_name is a variable label of the vecs, the other metrics keep the static DBName
+	vecLabels := labels
+	if config.DBNameFunc != nil {
+		labelNames = append(labelNames, "db_name")
+		vecLabels = make(map[string]string, len(labels))
+		for k, v := range labels {
+			vecLabels[k] = v
+		}
+		for _, name := range config.transformLabelNames([]string{"db_name"}) {
+			delete(vecLabels, name)
+		}
+	}
+	labelNames = config.transformLabelNames(labelNames)
+
+	stats := &QueryStats{
+		config: config,
+		Queries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        config.metricName("", "queries_total"),
+			Help:        config.help(config.metricName("", "queries_total"), "The total number of operations executed."),
+			ConstLabels: vecLabels,
+		}, labelNames),
+		Errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        config.metricName("", "query_errors_total"),
+			Help:        config.help(config.metricName("", "query_errors_total"), "The total number of operations failed, excluding record not found and cancellations."),
+			ConstLabels: vecLabels,
+		}, labelNames),
+		Cancelled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        config.metricName("", "queries_cancelled_total"),
+			Help:        config.help(config.metricName("", "queries_cancelled_total"), "The total number of operations cancelled or timed out by their context."),
+			ConstLabels: vecLabels,
+		}, labelNames),
+		Deadlocks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        config.metricName("", "query_deadlocks_total"),
+			Help:        config.help(config.metricName("", "query_deadlocks_total"), "The total number of operations failed with a deadlock, also counted in errors."),
+			ConstLabels: vecLabels,
+		}, labelNames),
+		LockWaits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        config.metricName("", "query_lock_wait_timeouts_total"),
+			Help:        config.help(config.metricName("", "query_lock_wait_timeouts_total"), "The total number of operations failed with a lock wait timeout, also counted in errors."),
+			ConstLabels: vecLabels,
+		}, labelNames),
+		InFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        config.metricName("", "queries_in_flight"),
+			Help:        config.help(config.metricName("", "queries_in_flight"), "The number of operations currently executing, including those waiting for a connection."),
+			ConstLabels: labels,
+		}),
+		BatchSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        config.metricName("", "create_batch_size"),
+			Help:        config.help(config.metricName("", "create_batch_size"), "The number of rows per create statement, e.g. per batch of CreateInBatches."),
+			ConstLabels: labels,
+			Buckets:     defaultRowsBuckets,
+		}),
+		Deletes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        config.metricName("", "deletes_total"),
+			Help:        config.help(config.metricName("", "deletes_total"), "The total number of delete operations succeeded, by kind, soft or hard."),
+			ConstLabels: labels,
+		}, config.transformLabelNames([]string{"kind"})),
+		Upserts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        config.metricName("", "upserts_total"),
+			Help:        config.help(config.metricName("", "upserts_total"), "The total number of creates with an ON CONFLICT clause succeeded, by result."),
+			ConstLabels: labels,
+		}, config.transformLabelNames([]string{"result"})),
+		NoWhere: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        config.metricName("", "queries_without_where_total"),
+			Help:        config.help(config.metricName("", "queries_without_where_total"), "The total number of updates and deletes without a WHERE clause, by operation."),
+			ConstLabels: labels,
+		}, config.transformLabelNames([]string{"operation"})),
+		Preloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        config.metricName("", "query_preloads_total"),
+			Help:        config.help(config.metricName("", "query_preloads_total"), "The total number of queries preloading an association, by association."),
+			ConstLabels: labels,
+		}, config.transformLabelNames([]string{"association"})),
+		Joins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        config.metricName("", "query_joins_total"),
+			Help:        config.help(config.metricName("", "query_joins_total"), "The total number of queries joining an association, by association."),
+			ConstLabels: labels,
+		}, config.transformLabelNames([]string{"association"})),
+		Overflows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        config.metricName("", "label_sets_overflow_total"),
+			Help:        config.help(config.metricName("", "label_sets_overflow_total"), "The total number of label sets collapsed into overflow by MaxLabelSets, by metric."),
+			ConstLabels: labels,
+		}, config.transformLabelNames([]string{"metric"})),
+	}
+	stats.limiters = newLabelSetLimiters(stats.Overflows, config, "queries_total", "query_errors_total", "queries_cancelled_total",
+		"query_deadlocks_total", "query_lock_wait_timeouts_total", "query_duration_seconds", "query_sql_duration_seconds", "query_rows",
+		"slow_queries_total")
+
+	durationBuckets := config.DurationBuckets
+	if len(durationBuckets) == 0 {
+		durationBuckets = defaultDurationBuckets
+	}
+
+	if config.DurationSummary {
+		objectives := config.SummaryObjectives
+		if len(objectives) == 0 {
+			objectives = defaultSummaryObjectives
+		}
+
+		stats.Duration = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Name:        config.metricName("", "query_duration_seconds"),
+			Help:        config.help(config.metricName("", "query_duration_seconds"), "Time spent in operations including hooks, in seconds."),
+			ConstLabels: vecLabels,
+			Objectives:  objectives,
+		}, labelNames)
+	} else {
+		stats.Duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        config.metricName("", "query_duration_seconds"),
+			Help:        config.help(config.metricName("", "query_duration_seconds"), "Time spent in operations including hooks, in seconds."),
+			ConstLabels: vecLabels,
+			Buckets:     durationBuckets,
+		}, labelNames)
+	}
+
+	if config.SQLDuration {
+		stats.SQLDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        config.metricName("", "query_sql_duration_seconds"),
+			Help:        config.help(config.metricName("", "query_sql_duration_seconds"), "Time spent executing SQL excluding hooks, in seconds."),
+			ConstLabels: vecLabels,
+			Buckets:     durationBuckets,
+		}, labelNames)
+	}
+
+	if config.RowsMetrics {
+		rowsLabelNames := []string{"operation"}
+		if config.RowsByTable {
+			rowsLabelNames = append(rowsLabelNames, "table")
+		}
+		if config.DBNameFunc != nil {
+			rowsLabelNames = append(rowsLabelNames, "db_name")
+		}
+		rowsLabelNames = config.transformLabelNames(rowsLabelNames)
+
+		buckets := config.RowsBuckets
+		if len(buckets) == 0 {
+			buckets = defaultRowsBuckets
+		}
+
+		stats.Rows = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        config.metricName("", "query_rows"),
+			Help:        config.help(config.metricName("", "query_rows"), "The number of rows returned or affected per operation."),
+			ConstLabels: vecLabels,
+			Buckets:     buckets,
+		}, rowsLabelNames)
+	}
+
+	if config.TransactionMetrics {
+		stats.Savepoints = newSavepoints(labels, config)
+	}
+
+	if config.ExplainSampleRate > 0 {
+		stats.PlanCost = newPlanCost(labels, config)
+	}
+
+	if config.SlowQueryMetrics {
+		stats.SlowQueries = newSlowQueries(vecLabels, config, labelNames)
+	}
+
+	if config.CallerSampleRate > 0 {
+		stats.CallerDuration = newCallerDuration(labels, config, durationBuckets)
+	}
+
+	if config.PreparedStmtMetrics && preparedStmt {
+		stats.PreparedStmtHits, stats.PreparedStmtMisses = newPreparedStmtCounters(labels, config)
+		stats.PreparedStmts = newPreparedStmts(labels, config)
+		stats.stmtTables = map[string]string{}
+	}
+
+	return stats
+}
+
+// get collectors in query stats
+func (stats *QueryStats) Collectors() []prometheus.Collector {
+	collectors := []prometheus.Collector{stats.Queries, stats.Errors, stats.Cancelled, stats.Deadlocks, stats.LockWaits, stats.Duration, stats.InFlight, stats.BatchSize, stats.Deletes, stats.Upserts, stats.NoWhere, stats.Preloads, stats.Joins, stats.Overflows}
+	if stats.SQLDuration != nil {
+		collectors = append(collectors, stats.SQLDuration)
+	}
+	if stats.Rows != nil {
+		collectors = append(collectors, stats.Rows)
+	}
+	if stats.PreparedStmtHits != nil {
+		collectors = append(collectors, stats.PreparedStmtHits, stats.PreparedStmtMisses, stats.PreparedStmts)
+	}
+	if stats.Savepoints != nil {
+		collectors = append(collectors, stats.Savepoints)
+	}
+	if stats.PlanCost != nil {
+		collectors = append(collectors, stats.PlanCost)
+	}
+	if stats.SlowQueries != nil {
+		collectors = append(collectors, stats.SlowQueries)
+	}
+	if stats.CallerDuration != nil {
+		collectors = append(collectors, stats.CallerDuration)
+	}
+	return collectors
+}
+
+// callbackProcessor registers callbacks around a gorm processor and around the callback executing its SQL
+type callbackProcessor struct {
+	operation string
+	sql       string // name of the callback executing the SQL
+	next      string // name of the callback following it, after SQL callbacks must run before it
+	register  func(before, after, name string, fn func(*gorm.DB)) error
+}
+
+func (cp callbackProcessor) before(name string, fn func(*gorm.DB)) error {
+	return cp.register("*", "", name, fn)
+}
+
+func (cp callbackProcessor) after(name string, fn func(*gorm.DB)) error {
+	return cp.register("", "*", name, fn)
+}
+
+func (cp callbackProcessor) beforeSQL(name string, fn func(*gorm.DB)) error {
+	return cp.register(cp.sql, "", name, fn)
+}
+
+func (cp callbackProcessor) afterSQL(name string, fn func(*gorm.DB)) error {
+	return cp.register(cp.next, cp.sql, name, fn)
+}
+
+// callbackProcessors returns the gorm processors, each registration creates a new callback
+// as a gorm callback can only be registered once
+func callbackProcessors(db *gorm.DB) []callbackProcessor {
+	cb := db.Callback()
+	return []callbackProcessor{
+		{"create", "gorm:create", "gorm:save_after_associations", func(before, after, name string, fn func(*gorm.DB)) error {
+			return cb.Create().Before(before).After(after).Register(name, fn)
+		}},
+		{"query", "gorm:query", "gorm:preload", func(before, after, name string, fn func(*gorm.DB)) error {
+			return cb.Query().Before(before).After(after).Register(name, fn)
+		}},
+		{"update", "gorm:update", "gorm:save_after_associations", func(before, after, name string, fn func(*gorm.DB)) error {
+			return cb.Update().Before(before).After(after).Register(name, fn)
+		}},
+		{"delete", "gorm:delete", "gorm:after_delete", func(before, after, name string, fn func(*gorm.DB)) error {
+			return cb.Delete().Before(before).After(after).Register(name, fn)
+		}},
+		{"row", "gorm:row", "", func(before, after, name string, fn func(*gorm.DB)) error {
+			return cb.Row().Before(before).After(after).Register(name, fn)
+		}},
+		{"raw", "gorm:raw", "", func(before, after, name string, fn func(*gorm.DB)) error {
+			return cb.Raw().Before(before).After(after).Register(name, fn)
+		}},
+	}
+}
+
+// unknownOperations returns an error listing the Operations that aren't gorm processors, e.g. a typo, as they
+// would silently record nothing
+func unknownOperations(db *gorm.DB, operations []string) error {
+	processors := callbackProcessors(db)
+	known := make(map[string]bool, len(processors))
+	names := make([]string, 0, len(processors))
+	for _, cp := range processors {
+		known[cp.operation] = true
+		names = append(names, cp.operation)
+	}
+
+	var unknown []string
+	for _, operation := range operations {
+		if !known[operation] {
+			unknown = append(unknown, strconv.Quote(operation))
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("gorm:prometheus unknown Operations %s, expected %s", strings.Join(unknown, ", "), strings.Join(names, ", "))
+	}
+	return nil
+}
+
+func (stats *QueryStats) registerCallbacks(db *gorm.DB) error {
+	if err := stats.parseTableLabels(db); err != nil {
+		return err
+	}
+
+	if stats.SlowQueries != nil {
+		if stats.slowThreshold = slowThreshold(db.Logger); stats.slowThreshold == 0 {
+			db.Logger.Warn(context.Background(), "gorm:prometheus SlowQueryMetrics requires a logger created by logger.New with a SlowThreshold")
+		}
+	}
+
+	stats.operations = nil
+	operations := make(map[string]bool, len(stats.config.Operations))
+	for _, operation := range stats.config.Operations {
+		operations[operation] = true
+	}
+
+	for _, cp := range callbackProcessors(db) {
+		if cp.operation == "raw" && stats.Savepoints != nil {
+			if err := cp.after("prometheus:savepoints", stats.countSavepoint); err != nil {
+				return err
+			}
+		}
+
+		if len(operations) > 0 && !operations[cp.operation] {
+			continue
+		}
+
+		if err := cp.before("prometheus:before_"+cp.operation, stats.before(cp.operation)); err != nil {
+			return err
+		}
+
+		if err := cp.after("prometheus:after_"+cp.operation, stats.after(cp.operation)); err != nil {
+			return err
+		}
+		stats.operations = append(stats.operations, cp.operation)
+
+		if stats.PreparedStmtHits != nil || stats.config.ExecutionLabel {
+			if err := cp.beforeSQL("prometheus:before_prepared_stmt_"+cp.operation, stats.beforePreparedStmt); err != nil {
+				return err
+			}
+
+			if err := cp.afterSQL("prometheus:after_prepared_stmt_"+cp.operation, stats.afterPreparedStmt); err != nil {
+				return err
+			}
+		}
+
+		if stats.SQLDuration == nil {
+			continue
+		}
+
+		if err := cp.beforeSQL("prometheus:before_sql_"+cp.operation, stats.beforeSQL); err != nil {
+			return err
+		}
+
+		if err := cp.afterSQL("prometheus:after_sql_"+cp.operation, stats.afterSQL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (stats *QueryStats) before(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		db.InstanceSet(startedAtKey, time.Now())
+		stats.InFlight.Inc()
+		if stats.reuse != nil {
+			atomic.AddUint64(&stats.reuse.operations, 1)
+		}
+		if stats.config.PoolLabel {
+			// resolved before the SQL is built, the same as dbresolver does
+			db.InstanceSet(poolKey, resolvedPool(db, operation))
+		}
+		if stats.SQLDuration != nil {
+			db.InstanceSet(sqlDurationKey, nil) // the statement may be reused by the session
+		}
+		if stats.config.ExecutionLabel {
+			db.InstanceSet(executionKey, nil)
+		}
+	}
+}
+
+func (stats *QueryStats) beforeSQL(db *gorm.DB) {
+	db.InstanceSet(sqlStartedAtKey, time.Now())
+}
+
+func (stats *QueryStats) afterSQL(db *gorm.DB) {
+	if startedAt, ok := db.InstanceGet(sqlStartedAtKey); ok {
+		db.InstanceSet(sqlDurationKey, time.Since(startedAt.(time.Time)))
+	}
+}
+
+func (stats *QueryStats) after(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		startedAt, ok := db.InstanceGet(startedAtKey)
+		if !ok {
+			return
+		}
+		stats.InFlight.Dec()
+
+		labels := stats.labels(db, operation)
+		stats.Queries.With(stats.limit("queries_total", labels)).Inc()
+		switch err := db.Error; {
+		case err == nil || errors.Is(err, gorm.ErrRecordNotFound):
+		case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+			stats.Cancelled.With(stats.limit("queries_cancelled_total", labels)).Inc() // client side, not counted as an error
+		default:
+			stats.Errors.With(stats.limit("query_errors_total", labels)).Inc()
+			switch lockError(err) {
+			case "deadlock":
+				stats.Deadlocks.With(stats.limit("query_deadlocks_total", labels)).Inc()
+			case "lock_wait_timeout":
+				stats.LockWaits.With(stats.limit("query_lock_wait_timeouts_total", labels)).Inc()
+			}
+		}
+		duration := time.Since(startedAt.(time.Time))
+		if duration >= stats.config.MinDuration {
+			stats.observe(stats.Duration.With(stats.limit("query_duration_seconds", labels)), db.Statement.Context, duration.Seconds())
+		}
+		if stats.slowThreshold > 0 && duration > stats.slowThreshold { // the same comparison as the logger
+			stats.SlowQueries.With(stats.limit("slow_queries_total", labels)).Inc()
+		}
+		if stats.CallerDuration != nil {
+			stats.observeCaller(operation, duration.Seconds())
+		}
+
+		// the SQL duration is an estimate, it also includes anything registered around the SQL callback
+		if v, ok := db.InstanceGet(sqlDurationKey); ok && stats.SQLDuration != nil {
+			if sqlDuration, ok := v.(time.Duration); ok && sqlDuration >= stats.config.MinDuration {
+				stats.SQLDuration.With(stats.limit("query_sql_duration_seconds", labels)).Observe(sqlDuration.Seconds())
+			}
+		}
+
+		// rows of the row operation are only known once the caller iterates them
+		if stats.Rows != nil && operation != "row" {
+			rowsLabels := prometheus.Labels{"operation": operation}
+			if stats.config.RowsByTable {
+				rowsLabels["table"] = stats.rowsTable(db.Statement.Table)
+			}
+			if stats.config.DBNameFunc != nil {
+				rowsLabels["db_name"] = stats.dbName(db)
+			}
+			stats.Rows.With(stats.limit("query_rows", stats.config.transformLabels(rowsLabels))).Observe(float64(db.Statement.RowsAffected))
+		}
+
+		if operation == "create" && db.Error == nil {
+			stats.BatchSize.Observe(float64(batchSize(db)))
+
+			if _, ok := db.Statement.Clauses["ON CONFLICT"]; ok {
+				stats.Upserts.With(stats.config.transformLabels(map[string]string{"result": upsertResult(db)})).Inc()
+			}
+		}
+
+		if (operation == "update" || operation == "delete") && !hasWhere(db) {
+			// counted even if gorm refused it with ErrMissingWhereClause, to catch the attempts
+			stats.NoWhere.With(stats.config.transformLabels(map[string]string{"operation": operation})).Inc()
+			if db.Error == nil {
+				db.Logger.Warn(db.Statement.Context, "gorm:prometheus %s without WHERE clause on %s", operation, db.Statement.Table)
+			}
+		}
+
+		if operation == "query" && db.Error == nil && stats.PlanCost != nil {
+			stats.sampleExplain(db)
+		}
+
+		if operation == "query" {
+			for name := range db.Statement.Preloads {
+				stats.Preloads.With(stats.associationLabels(name)).Inc()
+			}
+			for _, join := range db.Statement.Joins {
+				stats.Joins.With(stats.associationLabels(join.Name)).Inc()
+			}
+		}
+
+		if operation == "delete" && db.Error == nil {
+			stats.Deletes.With(stats.config.transformLabels(map[string]string{"kind": deleteKind(db)})).Inc()
+		}
+	}
+}
+
+// associationLabels returns the labels of the association metrics, associations not in AssociationLabels are "other"
+func (stats *QueryStats) associationLabels(name string) prometheus.Labels {
+	association := "other"
+	for _, allowed := range stats.config.AssociationLabels {
+		if allowed == name {
+			association = name
+			break
+		}
+	}
+	return stats.config.transformLabels(map[string]string{"association": association})
+}
+
+// origin returns the origin of the statement context, origins beyond MaxOrigins distinct values are "other"
+func (stats *QueryStats) origin(ctx context.Context) string {
+	if ctx == nil {
+		return "unknown"
+	}
+
+	origin := stats.config.OriginFromContext(ctx)
+	if origin == "" {
+		return "unknown"
+	}
+
+	stats.originsLock.RLock()
+	seen := stats.origins[origin]
+	stats.originsLock.RUnlock()
+	if seen {
+		return origin
+	}
+
+	stats.originsLock.Lock()
+	defer stats.originsLock.Unlock()
+	if stats.origins == nil {
+		stats.origins = map[string]bool{}
+	}
+	if !stats.origins[origin] {
+		if len(stats.origins) >= stats.config.maxOrigins() {
+			return "other"
+		}
+		stats.origins[origin] = true
+	}
+	return origin
+}
+
+// rowsTable returns the table labeling the rows histogram, tables not in TableLabels when set, or beyond
+// MaxTables distinct tables otherwise, are "other"
+func (stats *QueryStats) rowsTable(table string) string {
+	if table == "" {
+		return "other"
+	}
+	if len(stats.tables) > 0 {
+		if stats.tables[table] {
+			return table
+		}
+		return "other"
+	}
+
+	stats.rowsTablesLock.RLock()
+	seen := stats.rowsTables[table]
+	stats.rowsTablesLock.RUnlock()
+	if seen {
+		return table
+	}
+
+	stats.rowsTablesLock.Lock()
+	defer stats.rowsTablesLock.Unlock()
+	if stats.rowsTables == nil {
+		stats.rowsTables = map[string]bool{}
+	}
+	if !stats.rowsTables[table] {
+		if len(stats.rowsTables) >= stats.config.maxTables() {
+			return "other"
+		}
+		stats.rowsTables[table] = true
+	}
+	return table
+}
+
+// statementTypes are the SQL verbs labeled by StatementLabel
+var statementTypes = map[string]bool{"select": true, "insert": true, "update": true, "delete": true, "replace": true, "call": true, "show": true}
+
+// statementType returns the lowercase verb of the SQL, e.g. select, "other" if it isn't a statementTypes verb
+func statementType(sql string) string {
+	sql = strings.TrimLeft(sql, " \t\r\n(")
+	end := strings.IndexAny(sql, " \t\r\n(")
+	if end < 0 {
+		end = len(sql)
+	}
+
+	if verb := strings.ToLower(sql[:end]); statementTypes[verb] {
+		return verb
+	}
+	return "other"
+}
+
+// hasWhere reports whether the statement has WHERE conditions from db.Statement.Clauses, which include the
+// primary key of the model, the deleted at condition added by soft deletes doesn't count
+func hasWhere(db *gorm.DB) bool {
+	c, ok := db.Statement.Clauses["WHERE"]
+	if !ok {
+		return false
+	}
+
+	where, _ := c.Expression.(clause.Where)
+	for _, expr := range where.Exprs {
+		if !isSoftDeleteCondition(db, expr) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSoftDeleteCondition reports whether expr is the condition on the soft delete field of the model
+func isSoftDeleteCondition(db *gorm.DB, expr clause.Expression) bool {
+	eq, ok := expr.(clause.Eq)
+	if !ok || db.Statement.Schema == nil {
+		return false
+	}
+
+	column, ok := eq.Column.(clause.Column)
+	if !ok || column.Table != clause.CurrentTable {
+		return false
+	}
+
+	// the same as gorm finds the soft delete field when parsing the schema
+	field := db.Statement.Schema.LookUpField(column.Name)
+	if field == nil {
+		return false
+	}
+	_, ok = reflect.New(field.IndirectFieldType).Interface().(schema.DeleteClausesInterface)
+	return ok
+}
+
+// upsertResult returns whether a single row upsert inserted or updated the row, MySQL reports 1 affected row
+// for an insert and 2 for an update (0 if the values didn't change), other drivers and batches are "unknown"
+func upsertResult(db *gorm.DB) string {
+	if db.Dialector.Name() != "mysql" || batchSize(db) != 1 {
+		return "unknown"
+	}
+
+	if db.Statement.RowsAffected == 1 {
+		return "inserted"
+	}
+	return "updated"
+}
+
+// lockErrorCodes are the driver error codes of lock contention, MySQL error numbers and Postgres SQLSTATEs
+var lockErrorCodes = map[string]string{
+	"1213":  "deadlock",          // MySQL ER_LOCK_DEADLOCK
+	"40P01": "deadlock",          // Postgres deadlock_detected
+	"1205":  "lock_wait_timeout", // MySQL ER_LOCK_WAIT_TIMEOUT
+	"55P03": "lock_wait_timeout", // Postgres lock_not_available, e.g. lock_timeout or NOWAIT
+}
+
+// lockError returns "deadlock" or "lock_wait_timeout" if err is a driver error of lock contention, "" otherwise
+func lockError(err error) string {
+	return lockErrorCodes[sqlErrorCode(err)]
+}
+
+// sqlErrorCode returns the code of the driver error wrapped by err, the SQLSTATE of Postgres drivers (pgconn.PgError,
+// pq.Error) or the error number of go-sql-driver/mysql MySQLError. Found by method or field name, the plugin
+// doesn't depend on the drivers
+func sqlErrorCode(err error) string {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if v := reflect.Indirect(reflect.ValueOf(err)); v.Kind() == reflect.Struct {
+			if number := v.FieldByName("Number"); number.Kind() == reflect.Uint16 {
+				return strconv.FormatUint(number.Uint(), 10)
+			}
+		}
+
+		if e, ok := err.(interface{ SQLState() string }); ok {
+			return e.SQLState()
+		}
+	}
+	return ""
+}
+
+// deleteKind returns "soft" if the delete was turned into an update by the soft delete clause of a gorm.DeletedAt
+// field, which sets the deleted at column with a SET clause, "hard" otherwise, e.g. for Unscoped deletes
+func deleteKind(db *gorm.DB) string {
+	if _, ok := db.Statement.Clauses["SET"]; ok {
+		return "soft"
+	}
+	return "hard"
+}
+
+// batchSize returns the number of rows created by the statement, CreateInBatches creates a statement per batch
+func batchSize(db *gorm.DB) int {
+	switch db.Statement.ReflectValue.Kind() {
+	case reflect.Slice, reflect.Array:
+		return db.Statement.ReflectValue.Len()
+	case reflect.Struct:
+		return 1
+	}
+	return int(db.Statement.RowsAffected) // e.g. maps
+}
+
+// observe records the value with an exemplar if ExemplarFromContext returns one for the statement context
+func (stats *QueryStats) observe(observer prometheus.Observer, ctx context.Context, value float64) {
+	if stats.config.ExemplarFromContext != nil && ctx != nil {
+		if exemplar := stats.config.ExemplarFromContext(ctx); len(exemplar) > 0 {
+			if eo, ok := observer.(prometheus.ExemplarObserver); ok {
+				eo.ObserveWithExemplar(value, exemplar)
+				return
+			}
+		}
+	}
+	observer.Observe(value)
+}
+
+// labels returns the label values of the query metrics for the statement
+func (stats *QueryStats) labels(db *gorm.DB, operation string) prometheus.Labels {
+	labels := prometheus.Labels{"operation": operation}
+	if stats.config.PoolLabel {
+		pool, _ := db.InstanceGet(poolKey)
+		labels["pool"], _ = pool.(string)
+	}
+	if len(stats.tables) > 0 {
+		labels["table"] = "other"
+		if stats.tables[db.Statement.Table] {
+			labels["table"] = db.Statement.Table
+		}
+	}
+	if stats.config.StatementLabel {
+		labels["statement"] = statementType(db.Statement.SQL.String())
+	}
+	if stats.config.OriginFromContext != nil {
+		labels["origin"] = stats.origin(db.Statement.Context)
+	}
+	if stats.config.ExecutionLabel {
+		labels["execution"] = "direct"
+		if execution, ok := db.InstanceGet(executionKey); ok && execution != nil {
+			labels["execution"] = execution.(string)
+		}
+	}
+	if stats.config.DBNameFunc != nil {
+		labels["db_name"] = stats.dbName(db)
+	}
+	return stats.config.transformLabels(labels)
+}
+
+// parseTableLabels resolves the table names of TableLabels, models are parsed with the naming strategy of db
+func (stats *QueryStats) parseTableLabels(db *gorm.DB) error {
+	if stats.tables == nil {
+		stats.tables = make(map[string]bool, len(stats.config.TableLabels))
+	}
+
+	for _, table := range stats.config.TableLabels {
+		if name, ok := table.(string); ok {
+			stats.tables[name] = true
+			continue
+		}
+
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(table); err != nil {
+			return err
+		}
+		stats.tables[stmt.Table] = true
+	}
+	return nil
+}
+
+// resolvedPool returns the pool gorm.io/plugin/dbresolver routes the statement to, "source" or "replica",
+// following the same rules as the resolver, statements in a transaction are assumed to run on the source
+// unless the transaction began on a replica
+func resolvedPool(db *gorm.DB, operation string) string {
+	_, write := db.Statement.Settings.Load(dbResolverWrite)
+	_, read := db.Statement.Settings.Load(dbResolverRead)
+
+	if _, ok := db.Statement.ConnPool.(gorm.TxCommitter); ok {
+		if read {
+			return "replica"
+		}
+		return "source"
+	}
+
+	rawSQL := strings.TrimSpace(db.Statement.SQL.String())
+	switch operation {
+	case "create", "update", "delete":
+		return "source"
+	case "query", "row":
+		if rawSQL == "" {
+			if _, locking := db.Statement.Clauses["FOR"]; write || locking {
+				return "source"
+			}
+			return "replica"
+		}
+	}
+
+	// guess from the SQL
+	switch {
+	case write:
+		return "source"
+	case read:
+		return "replica"
+	case len(rawSQL) > 10 && strings.EqualFold(rawSQL[:6], "select") && !strings.EqualFold(rawSQL[len(rawSQL)-10:], "for update"):
+		return "replica"
+	default:
+		return "source"
+	}
+}
@@ -0,0 +1,295 @@
+package prometheus
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils/tests"
+)
+
+// newMockGorm returns a DB backed by sqlmock with the gorm callbacks registered, opened with config
+func newMockGorm(t *testing.T, config *gorm.Config) (*gorm.DB, sqlmock.Sqlmock) {
+	t.Helper()
+
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock, got error: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	config.ConnPool = sqlDB
+	config.Logger = logger.Discard
+	config.SkipDefaultTransaction = true
+	db, err := gorm.Open(tests.DummyDialector{}, config)
+	if err != nil {
+		t.Fatalf("failed to open gorm, got error: %v", err)
+	}
+	return db, mock
+}
+
+// newMockDB returns a DB backed by sqlmock and a plugin used by it without background work, its metrics
+// registered in a registry of its own
+func newMockDB(t *testing.T, config Config) (*gorm.DB, sqlmock.Sqlmock, *Prometheus) {
+	t.Helper()
+
+	db, mock := newMockGorm(t, &gorm.Config{})
+	return db, mock, usePlugin(t, db, config)
+}
+
+// usePlugin uses a plugin created from config by db, without background work
+func usePlugin(t *testing.T, db *gorm.DB, config Config) *Prometheus {
+	t.Helper()
+
+	config.Registry = prometheus.NewRegistry()
+	config.ManualStart = true
+	p := New(config)
+	if err := db.Use(p); err != nil {
+		t.Fatalf("failed to use the plugin, got error: %v", err)
+	}
+	t.Cleanup(p.Stop)
+	return p
+}
+
+// histogramOf returns the histogram of the observer
+func histogramOf(t *testing.T, observer prometheus.Observer) *dto.Histogram {
+	t.Helper()
+
+	var m dto.Metric
+	if err := observer.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatalf("failed to write the metric, got error: %v", err)
+	}
+	return m.GetHistogram()
+}
+
+// slowHook spends time in an AfterFind hook, outside of the SQL
+type slowHook struct {
+	ID   uint
+	Name string
+}
+
+func (slowHook) AfterFind(*gorm.DB) error {
+	time.Sleep(20 * time.Millisecond)
+	return nil
+}
+
+func TestSQLDuration(t *testing.T) {
+	db, mock, p := newMockDB(t, Config{CallbackMetrics: true, SQLDuration: true})
+	mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "jinzhu"))
+
+	var hook slowHook
+	if err := db.First(&hook).Error; err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+
+	total := histogramOf(t, p.QueryStats.Duration.With(prometheus.Labels{"operation": "query"}))
+	sql := histogramOf(t, p.QueryStats.SQLDuration.With(prometheus.Labels{"operation": "query"}))
+	if total.GetSampleCount() != 1 || sql.GetSampleCount() != 1 {
+		t.Fatalf("expected one observation of each duration, got %d and %d", total.GetSampleCount(), sql.GetSampleCount())
+	}
+	if total.GetSampleSum() < 0.02 {
+		t.Errorf("expected the duration to include the hook, got %vs", total.GetSampleSum())
+	}
+	if hooks := total.GetSampleSum() - sql.GetSampleSum(); hooks < 0.02 {
+		t.Errorf("expected the SQL duration to exclude the hook, got %vs apart", hooks)
+	}
+}
+
+// mysqlError has the fields of go-sql-driver/mysql MySQLError
+type mysqlError struct {
+	Number   uint16
+	SQLState [5]byte
+	Message  string
+}
+
+func (e *mysqlError) Error() string {
+	return "Error " + strconv.Itoa(int(e.Number)) + ": " + e.Message
+}
+
+// pgError has the SQLState method of pgconn.PgError
+type pgError struct{ code string }
+
+func (e *pgError) Error() string    { return "ERROR (SQLSTATE " + e.code + ")" }
+func (e *pgError) SQLState() string { return e.code }
+
+func TestLockError(t *testing.T) {
+	for err, expected := range map[error]string{
+		&mysqlError{Number: 1213, Message: "Deadlock found when trying to get lock"}: "deadlock",
+		&mysqlError{Number: 1205, Message: "Lock wait timeout exceeded"}:             "lock_wait_timeout",
+		&mysqlError{Number: 1062, Message: "Duplicate entry"}:                        "",
+		&pgError{code: "40P01"}:                  "deadlock",
+		&pgError{code: "55P03"}:                  "lock_wait_timeout",
+		errors.New("Error 1213: Deadlock found"): "",
+	} {
+		if kind := lockError(err); kind != expected {
+			t.Errorf("expected %q for %v, got %q", expected, err, kind)
+		}
+	}
+
+	wrapped := &wrappedError{&mysqlError{Number: 1213}}
+	if kind := lockError(wrapped); kind != "deadlock" {
+		t.Errorf("expected deadlock for a wrapped error, got %q", kind)
+	}
+}
+
+type wrappedError struct{ err error }
+
+func (e *wrappedError) Error() string { return "wrapped: " + e.err.Error() }
+func (e *wrappedError) Unwrap() error { return e.err }
+
+func TestRowsByTableBound(t *testing.T) {
+	db, mock, p := newMockDB(t, Config{CallbackMetrics: true, RowsMetrics: true, RowsByTable: true, MaxTables: 1})
+	for _, table := range []string{"users", "orders_2024_01", "users"} {
+		mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		if err := db.Table(table).Find(&[]map[string]interface{}{}).Error; err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+	}
+	mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(0, 3))
+	if err := db.Exec("UPDATE users SET name = ?", "jinzhu").Error; err != nil {
+		t.Fatalf("raw SQL failed: %v", err)
+	}
+
+	for table, count := range map[string]uint64{"users": 2, "other": 1} {
+		if n := histogramOf(t, p.QueryStats.Rows.WithLabelValues("query", table)).GetSampleCount(); n != count {
+			t.Errorf("expected %d queries of %s, got %d", count, table, n)
+		}
+	}
+	if n := histogramOf(t, p.QueryStats.Rows.WithLabelValues("raw", "other")).GetSampleCount(); n != 1 {
+		t.Errorf("expected the raw SQL without table as other, got %d", n)
+	}
+}
+
+func TestUnknownOperations(t *testing.T) {
+	db, _ := newMockGorm(t, &gorm.Config{})
+	err := db.Use(New(Config{Registry: prometheus.NewRegistry(), ManualStart: true, CallbackMetrics: true, Operations: []string{"query", "udpate", "Create"}}))
+	if err == nil || !strings.Contains(err.Error(), `"udpate", "Create"`) {
+		t.Errorf("expected an error listing the unknown operations, got %v", err)
+	}
+
+	_, _, p := newMockDB(t, Config{CallbackMetrics: true, Operations: []string{"query", "update"}})
+	if len(p.QueryStats.operations) != 2 {
+		t.Errorf("expected the callbacks of 2 operations registered, got %v", p.QueryStats.operations)
+	}
+}
+
+// softDeleteUser is deleted with an UPDATE of its DeletedAt, unless Unscoped
+type softDeleteUser struct {
+	ID        uint
+	Name      string
+	DeletedAt gorm.DeletedAt
+}
+
+func TestDeletes(t *testing.T) {
+	db, mock, p := newMockDB(t, Config{CallbackMetrics: true})
+	mock.ExpectExec("UPDATE `soft_delete_users` SET `deleted_at`").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM `soft_delete_users`").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := db.Delete(&softDeleteUser{ID: 1}).Error; err != nil {
+		t.Fatalf("soft delete failed: %v", err)
+	}
+	if err := db.Unscoped().Delete(&softDeleteUser{ID: 1}).Error; err != nil {
+		t.Fatalf("hard delete failed: %v", err)
+	}
+
+	for kind, expected := range map[string]float64{"soft": 1, "hard": 1} {
+		if v := testutil.ToFloat64(p.QueryStats.Deletes.WithLabelValues(kind)); v != expected {
+			t.Errorf("expected %v %s deletes, got %v", expected, kind, v)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestQueriesWithoutWhere(t *testing.T) {
+	db, mock, p := newMockDB(t, Config{CallbackMetrics: true})
+	mock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE `soft_delete_users` SET `deleted_at`").WillReturnResult(sqlmock.NewResult(0, 3))
+
+	if err := db.Model(&softDeleteUser{}).Where("name = ?", "jinzhu").Update("name", "jinzhu2").Error; err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	// refused by gorm, still counted
+	if err := db.Model(&softDeleteUser{}).Update("name", "jinzhu2").Error; err != gorm.ErrMissingWhereClause {
+		t.Fatalf("expected ErrMissingWhereClause, got %v", err)
+	}
+	// only the deleted at condition of the soft delete
+	if err := db.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&softDeleteUser{}).Error; err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	for operation, expected := range map[string]float64{"update": 1, "delete": 1} {
+		if v := testutil.ToFloat64(p.QueryStats.NoWhere.WithLabelValues(operation)); v != expected {
+			t.Errorf("expected %v %ss without WHERE, got %v", expected, operation, v)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+// mysqlDialector names the dummy dialector mysql, for the rows affected by upserts to be reported
+type mysqlDialector struct {
+	tests.DummyDialector
+}
+
+func (mysqlDialector) Name() string {
+	return "mysql"
+}
+
+// upsertUser has no primary key generated by the database, created without RETURNING
+type upsertUser struct {
+	ID   string `gorm:"primaryKey"`
+	Name string
+}
+
+func TestUpserts(t *testing.T) {
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock, got error: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	db, err := gorm.Open(mysqlDialector{}, &gorm.Config{ConnPool: sqlDB, Logger: logger.Discard, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatalf("failed to open gorm, got error: %v", err)
+	}
+	p := usePlugin(t, db, Config{CallbackMetrics: true})
+
+	mock.ExpectExec("INSERT .* ON CONFLICT").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT .* ON CONFLICT").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	upsert := db.Clauses(clause.OnConflict{UpdateAll: true})
+	if err := upsert.Create(&upsertUser{ID: "u1", Name: "jinzhu"}).Error; err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	if err := upsert.Create(&upsertUser{ID: "u1", Name: "jinzhu2"}).Error; err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if err := db.Create(&upsertUser{ID: "u2", Name: "jinzhu"}).Error; err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	for result, expected := range map[string]float64{"inserted": 1, "updated": 1} {
+		if v := testutil.ToFloat64(p.QueryStats.Upserts.WithLabelValues(result)); v != expected {
+			t.Errorf("expected %v upserts %s, got %v", expected, result, v)
+		}
+	}
+	if n := testutil.CollectAndCount(p.QueryStats.Upserts); n != 2 {
+		t.Errorf("expected the plain create not counted, got %d series", n)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package prometheus
+
+import (
+	"math/rand"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultMaxCallers = 50
+
+func newCallerDuration(labels map[string]string, config *Config, buckets []float64) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:        config.metricName("", "query_caller_duration_seconds"),
+		Help:        config.help(config.metricName("", "query_caller_duration_seconds"), "Time spent in the operations sampled by CallerSampleRate, by operation and caller, in seconds."),
+		ConstLabels: labels,
+		Buckets:     buckets,
+	}, config.transformLabelNames([]string{"operation", "caller"}))
+}
+
+// maxCallers returns MaxCallers, or the default
+func (c *Config) maxCallers() int {
+	if c.MaxCallers > 0 {
+		return c.MaxCallers
+	}
+	return defaultMaxCallers
+}
+
+// observeCaller records the duration of a sample of the operations by caller
+func (stats *QueryStats) observeCaller(operation string, seconds float64) {
+	if rand.Float64() >= stats.config.CallerSampleRate {
+		return
+	}
+
+	labels := stats.config.transformLabels(map[string]string{"operation": operation, "caller": stats.caller()})
+	stats.CallerDuration.With(labels).Observe(seconds)
+}
+
+// caller returns the file and line of the first frame outside of gorm, its plugins and database/sql, e.g.
+// "service/orders.go:42", at most MaxCallers distinct callers are labeled, later ones are "other"
+func (stats *QueryStats) caller() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+
+	caller := "unknown"
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "gorm.io/") && !strings.HasPrefix(frame.Function, "database/sql") && frame.File != "" {
+			caller = filepath.Base(filepath.Dir(frame.File)) + "/" + filepath.Base(frame.File) + ":" + strconv.Itoa(frame.Line)
+			break
+		}
+		if !more {
+			break
+		}
+	}
+
+	stats.callersLock.Lock()
+	defer stats.callersLock.Unlock()
+	if stats.callers == nil {
+		stats.callers = map[string]bool{}
+	}
+	if !stats.callers[caller] {
+		if len(stats.callers) >= stats.config.maxCallers() {
+			return "other"
+		}
+		stats.callers[caller] = true
+	}
+	return caller
+}
@@ -0,0 +1,19 @@
+package prometheus
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCallerBound(t *testing.T) {
+	stats := &QueryStats{config: &Config{MaxCallers: 1}}
+	first := stats.caller()
+	if !strings.HasPrefix(first, "testing/testing.go:") {
+		t.Errorf("expected the first frame outside gorm.io, got %q", first)
+	}
+
+	stats.callers = map[string]bool{"service/other.go:1": true}
+	if caller := stats.caller(); caller != "other" {
+		t.Errorf("expected other past MaxCallers, got %q", caller)
+	}
+}
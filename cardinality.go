@@ -0,0 +1,91 @@
+package prometheus
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// overflowLabel is the value of every label of the label sets beyond the limit of a metric
+const overflowLabel = "overflow"
+
+// labelSetLimiter caps the distinct label sets of a metric, later label sets are collapsed into one
+type labelSetLimiter struct {
+	max      int
+	lock     sync.RWMutex
+	seen     map[string]bool
+	overflow prometheus.Counter
+}
+
+// limit returns labels, or labels with every value "overflow" once max distinct label sets were seen
+func (l *labelSetLimiter) limit(labels prometheus.Labels) prometheus.Labels {
+	key := labelSetKey(labels)
+
+	l.lock.RLock()
+	seen := l.seen[key]
+	l.lock.RUnlock()
+	if seen {
+		return labels
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if !l.seen[key] {
+		if len(l.seen) >= l.max {
+			l.overflow.Inc()
+			overflow := make(prometheus.Labels, len(labels))
+			for name := range labels {
+				overflow[name] = overflowLabel
+			}
+			return overflow
+		}
+		l.seen[key] = true
+	}
+	return labels
+}
+
+// labelSetKey identifies the label values, sorted by label name
+func labelSetKey(labels prometheus.Labels) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var key strings.Builder
+	for _, name := range names {
+		key.WriteString(labels[name])
+		key.WriteByte(0)
+	}
+	return key.String()
+}
+
+// newLabelSetLimiters creates the limiters of the metrics capped by MaxLabelSets or LabelSetLimits
+func newLabelSetLimiters(overflows *prometheus.CounterVec, config *Config, metrics ...string) map[string]*labelSetLimiter {
+	limiters := map[string]*labelSetLimiter{}
+	for _, metric := range metrics {
+		max := config.MaxLabelSets
+		if limit, ok := config.LabelSetLimits[metric]; ok {
+			max = limit
+		}
+
+		if max > 0 {
+			limiters[metric] = &labelSetLimiter{
+				max:      max,
+				seen:     map[string]bool{},
+				overflow: overflows.With(config.transformLabels(map[string]string{"metric": config.metricName("", metric)})),
+			}
+		}
+	}
+	return limiters
+}
+
+// limit applies the label set limit of the query metric to labels, if it has one
+func (stats *QueryStats) limit(metric string, labels prometheus.Labels) prometheus.Labels {
+	if limiter, ok := stats.limiters[metric]; ok {
+		return limiter.limit(labels)
+	}
+	return labels
+}
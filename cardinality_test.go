@@ -0,0 +1,46 @@
+package prometheus
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLabelSetLimits(t *testing.T) {
+	stats := newQueryStats(nil, &Config{MaxLabelSets: 2, LabelSetLimits: map[string]int{"query_duration_seconds": 1}}, false)
+
+	for _, tt := range []struct {
+		metric, operation, expected string
+	}{
+		{"queries_total", "query", "query"},
+		{"queries_total", "create", "create"},
+		{"queries_total", "update", overflowLabel},
+		{"queries_total", "query", "query"}, // seen before the limit
+		{"query_duration_seconds", "query", "query"},
+		{"query_duration_seconds", "create", overflowLabel},
+		{"deletes_total", "update", "update"}, // not limited
+	} {
+		labels := stats.limit(tt.metric, prometheus.Labels{"operation": tt.operation, "table": "users"})
+		if labels["operation"] != tt.expected {
+			t.Errorf("expected %s for %s of %s, got %s", tt.expected, tt.operation, tt.metric, labels["operation"])
+		}
+		if tt.expected == overflowLabel && labels["table"] != overflowLabel {
+			t.Errorf("expected every label of the overflow label set collapsed, got %v", labels)
+		}
+	}
+
+	if v := testutil.ToFloat64(stats.Overflows.WithLabelValues("gorm_queries_total")); v != 1 {
+		t.Errorf("expected 1 label set of gorm_queries_total in overflow, got %v", v)
+	}
+	if v := testutil.ToFloat64(stats.Overflows.WithLabelValues("gorm_query_duration_seconds")); v != 1 {
+		t.Errorf("expected 1 label set of gorm_query_duration_seconds in overflow, got %v", v)
+	}
+}
+
+func TestLabelSetLimitsUnlimited(t *testing.T) {
+	stats := newQueryStats(nil, &Config{}, false)
+	if len(stats.limiters) != 0 {
+		t.Errorf("expected no limit by default, got %d limiters", len(stats.limiters))
+	}
+}
@@ -0,0 +1,417 @@
+package prometheus
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type ConnStats struct {
+	Opened         prometheus.Counter     // The total number of connections opened by the connector.
+	Closed         prometheus.Counter     // The total number of connections closed.
+	Errors         prometheus.Counter     // The total number of failed connection attempts.
+	TokenRefreshes *prometheus.CounterVec // The total number of auth tokens fetched by AuthToken, by result, success or error.
+
+	// Statements executed on the connections, with DriverMetrics
+	Statements        *prometheus.CounterVec   // The total number of statements executed, by kind, query or exec.
+	StatementErrors   *prometheus.CounterVec   // The total number of statements failed, by kind.
+	StatementDuration *prometheus.HistogramVec // Time spent executing statements, by kind, in seconds.
+
+	config *Config
+}
+
+func newConnStats(labels map[string]string, config *Config) *ConnStats {
+	stats := &ConnStats{
+		config: config,
+		Opened: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        config.metricName("", "connections_opened_total"),
+			Help:        config.help(config.metricName("", "connections_opened_total"), "The total number of connections opened by the connector."),
+			ConstLabels: labels,
+		}),
+		Closed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        config.metricName("", "connections_closed_total"),
+			Help:        config.help(config.metricName("", "connections_closed_total"), "The total number of connections closed."),
+			ConstLabels: labels,
+		}),
+		Errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        config.metricName("", "connection_errors_total"),
+			Help:        config.help(config.metricName("", "connection_errors_total"), "The total number of failed connection attempts."),
+			ConstLabels: labels,
+		}),
+		TokenRefreshes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        config.metricName("", "auth_token_refreshes_total"),
+			Help:        config.help(config.metricName("", "auth_token_refreshes_total"), "The total number of auth tokens fetched by AuthToken, by result, success or error."),
+			ConstLabels: labels,
+		}, config.transformLabelNames([]string{"result"})),
+	}
+
+	if config.DriverMetrics {
+		buckets := config.DurationBuckets
+		if len(buckets) == 0 {
+			buckets = defaultDurationBuckets
+		}
+
+		kind := config.transformLabelNames([]string{"kind"})
+		stats.Statements = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        config.metricName("", "driver_statements_total"),
+			Help:        config.help(config.metricName("", "driver_statements_total"), "The total number of statements executed through the connector, including those of gorm, by kind, query or exec."),
+			ConstLabels: labels,
+		}, kind)
+		stats.StatementErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        config.metricName("", "driver_statement_errors_total"),
+			Help:        config.help(config.metricName("", "driver_statement_errors_total"), "The total number of statements failed through the connector, by kind."),
+			ConstLabels: labels,
+		}, kind)
+		stats.StatementDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        config.metricName("", "driver_statement_duration_seconds"),
+			Help:        config.help(config.metricName("", "driver_statement_duration_seconds"), "Time spent executing statements through the connector, by kind, in seconds."),
+			ConstLabels: labels,
+			Buckets:     buckets,
+		}, kind)
+	}
+
+	return stats
+}
+
+// get collectors in conn stats
+func (stats *ConnStats) Collectors() []prometheus.Collector {
+	collectors := []prometheus.Collector{stats.Opened, stats.Closed, stats.Errors, stats.TokenRefreshes}
+	if stats.Statements != nil {
+		collectors = append(collectors, stats.Statements, stats.StatementErrors, stats.StatementDuration)
+	}
+	return collectors
+}
+
+// observe records a statement of kind started at startedAt, with DriverMetrics
+func (stats *ConnStats) observe(kind string, startedAt time.Time, err error) {
+	if stats.Statements == nil || err == driver.ErrSkip { // skipped statements are executed again prepared
+		return
+	}
+
+	labels := stats.config.transformLabels(map[string]string{"kind": kind})
+	stats.Statements.With(labels).Inc()
+	stats.StatementDuration.With(labels).Observe(time.Since(startedAt).Seconds())
+	if err != nil {
+		stats.StatementErrors.With(labels).Inc()
+	}
+}
+
+// Connector wraps the connector to count connection opens, closes and errors as they happen, rather than
+// sampling DBStats. The DB must be opened from the returned connector, e.g.
+//
+//	sqlDB := sql.OpenDB(p.Connector(connector))
+//	db, err := gorm.Open(mysql.New(mysql.Config{Conn: sqlDB}), &gorm.Config{})
+//	db.Use(p)
+//
+// With DriverMetrics the statements executed on the connections are also counted and timed, those of gorm as
+// well as the raw database/sql calls bypassing the gorm callbacks, e.g. sqlDB.QueryContext:
+//
+//	p := prometheus.New(prometheus.Config{DBName: "db1", DriverMetrics: true})
+//	sqlDB := sql.OpenDB(p.Connector(connector))
+//
+// sql.Conn.Raw gets the wrapping conn, its Unwrap method returns the driver conn:
+//
+//	conn.Raw(func(dc interface{}) error {
+//		mysqlConn := dc.(interface{ Unwrap() driver.Conn }).Unwrap()
+//		...
+//	})
+func (p *Prometheus) Connector(connector driver.Connector) driver.Connector {
+	return &instrumentedConnector{Connector: connector, driver: p.Driver(connector.Driver()), stats: p.connStats()}
+}
+
+// Driver wraps the driver like Connector, for a DB opened by driver name, e.g.
+//
+//	sql.Register("instrumented-mysql", p.Driver(&mysql.MySQLDriver{}))
+//	sqlDB, err := sql.Open("instrumented-mysql", dsn)
+func (p *Prometheus) Driver(d driver.Driver) driver.Driver {
+	return &instrumentedDriver{Driver: d, stats: p.connStats()}
+}
+
+// AuthToken wraps the function fetching the auth token used as password by a cloud-managed database, e.g. an
+// AWS RDS IAM or GCP Cloud SQL token, to count the token fetches and their failures, which otherwise only show up
+// as connection errors. Call it from the hook the driver runs before connecting, with the DB opened from Connector
+// to also count the connection errors, e.g. with go-sql-driver/mysql:
+//
+//	token := p.AuthToken(fetchToken)
+//	cfg.Apply(mysql.BeforeConnect(func(ctx context.Context, cfg *mysql.Config) (err error) {
+//		cfg.Passwd, err = token(ctx)
+//		return err
+//	}))
+//	connector, _ := mysql.NewConnector(cfg)
+//	sqlDB := sql.OpenDB(p.Connector(connector))
+func (p *Prometheus) AuthToken(fetch func(context.Context) (string, error)) func(context.Context) (string, error) {
+	stats := p.connStats()
+	return func(ctx context.Context) (string, error) {
+		token, err := fetch(ctx)
+
+		result := "success"
+		if err != nil {
+			result = "error"
+		}
+		stats.TokenRefreshes.With(p.transformLabels(map[string]string{"result": result})).Inc()
+		return token, err
+	}
+}
+
+// connStats returns the ConnStats, created and registered on first use
+func (p *Prometheus) connStats() *ConnStats {
+	p.connOnce.Do(func() {
+		p.ConnStats = newConnStats(p.Labels, p.Config)
+		p.register(p.ConnStats.Collectors()...)
+	})
+	return p.ConnStats
+}
+
+type instrumentedConnector struct {
+	driver.Connector
+	driver driver.Driver
+	stats  *ConnStats
+}
+
+func (c *instrumentedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.stats.connected(c.Connector.Connect(ctx))
+}
+
+func (c *instrumentedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Close closes the wrapped connector if it's an io.Closer, as sql.DB.Close does for unwrapped connectors
+func (c *instrumentedConnector) Close() error {
+	if closer, ok := c.Connector.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+type instrumentedDriver struct {
+	driver.Driver
+	stats *ConnStats
+}
+
+func (d *instrumentedDriver) Open(name string) (driver.Conn, error) {
+	return d.stats.connected(d.Driver.Open(name))
+}
+
+// OpenConnector lets database/sql open the connections with the connector of the driver if it has one
+func (d *instrumentedDriver) OpenConnector(name string) (driver.Connector, error) {
+	dc, ok := d.Driver.(driver.DriverContext)
+	if !ok {
+		return &dsnConnector{name: name, driver: d}, nil
+	}
+
+	connector, err := dc.OpenConnector(name)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedConnector{Connector: connector, driver: d, stats: d.stats}, nil
+}
+
+// dsnConnector opens the connections of a driver without connector, as database/sql does
+type dsnConnector struct {
+	name   string
+	driver driver.Driver
+}
+
+func (c *dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.name)
+}
+
+func (c *dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// connected counts the connection opened or failed
+func (stats *ConnStats) connected(dc driver.Conn, err error) (driver.Conn, error) {
+	if err != nil {
+		stats.Errors.Inc()
+		return nil, err
+	}
+
+	stats.Opened.Inc()
+	return &instrumentedConn{Conn: dc, stats: stats}, nil
+}
+
+// instrumentedConn forwards the optional driver interfaces so database/sql behaves as with the wrapped conn, their
+// fallbacks do what database/sql does when the wrapped conn doesn't implement them
+type instrumentedConn struct {
+	driver.Conn
+	stats *ConnStats
+}
+
+// Unwrap returns the wrapped driver conn, e.g. from sql.Conn.Raw, which gets the instrumentedConn
+func (c *instrumentedConn) Unwrap() driver.Conn {
+	return c.Conn
+}
+
+func (c *instrumentedConn) Close() error {
+	c.stats.Closed.Inc()
+	return c.Conn.Close()
+}
+
+func (c *instrumentedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if cb, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return cb.BeginTx(ctx, opts)
+	}
+
+	// the options can't be applied by Begin, they fail as database/sql does for unwrapped conns
+	if opts.Isolation != 0 {
+		return nil, errors.New("gorm:prometheus the driver does not support non-default isolation level")
+	}
+	if opts.ReadOnly {
+		return nil, errors.New("gorm:prometheus the driver does not support read-only transactions")
+	}
+	return c.Conn.Begin()
+}
+
+func (c *instrumentedConn) PrepareContext(ctx context.Context, query string) (stmt driver.Stmt, err error) {
+	if cp, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = cp.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+
+	if err != nil || c.stats.Statements == nil {
+		return stmt, err
+	}
+
+	// the ColumnConverter of the statement is only exposed if it has one, database/sql converts the arguments
+	// with the default converter otherwise
+	if _, ok := stmt.(driver.ColumnConverter); ok {
+		return &columnConverterStmt{instrumentedStmt{Stmt: stmt, conn: c}}, nil
+	}
+	return &instrumentedStmt{Stmt: stmt, conn: c}, nil
+}
+
+func (c *instrumentedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (rows driver.Rows, err error) {
+	startedAt := time.Now()
+	switch q := c.Conn.(type) {
+	case driver.QueryerContext:
+		rows, err = q.QueryContext(ctx, query, args)
+	case driver.Queryer: // as database/sql does for drivers without context support
+		var values []driver.Value
+		if values, err = namedValues(args); err == nil {
+			if err = ctx.Err(); err == nil {
+				rows, err = q.Query(query, values)
+			}
+		}
+	default:
+		return nil, driver.ErrSkip
+	}
+	c.stats.observe("query", startedAt, err)
+	return rows, err
+}
+
+func (c *instrumentedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (result driver.Result, err error) {
+	startedAt := time.Now()
+	switch e := c.Conn.(type) {
+	case driver.ExecerContext:
+		result, err = e.ExecContext(ctx, query, args)
+	case driver.Execer: // as database/sql does for drivers without context support
+		var values []driver.Value
+		if values, err = namedValues(args); err == nil {
+			if err = ctx.Err(); err == nil {
+				result, err = e.Exec(query, values)
+			}
+		}
+	default:
+		return nil, driver.ErrSkip
+	}
+	c.stats.observe("exec", startedAt, err)
+	return result, err
+}
+
+func (c *instrumentedConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *instrumentedConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *instrumentedConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *instrumentedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// instrumentedStmt times the executions of a prepared statement, with DriverMetrics
+type instrumentedStmt struct {
+	driver.Stmt
+	conn *instrumentedConn
+}
+
+func (s *instrumentedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (result driver.Result, err error) {
+	startedAt := time.Now()
+	if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+		result, err = e.ExecContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValues(args); err == nil {
+			result, err = s.Stmt.Exec(values)
+		}
+	}
+	s.conn.stats.observe("exec", startedAt, err)
+	return result, err
+}
+
+func (s *instrumentedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (rows driver.Rows, err error) {
+	startedAt := time.Now()
+	if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = q.QueryContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValues(args); err == nil {
+			rows, err = s.Stmt.Query(values)
+		}
+	}
+	s.conn.stats.observe("query", startedAt, err)
+	return rows, err
+}
+
+// CheckNamedValue forwards to the statement, or to the connection as database/sql does for unwrapped statements
+func (s *instrumentedStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return s.conn.CheckNamedValue(nv)
+}
+
+// columnConverterStmt is an instrumentedStmt of a statement implementing driver.ColumnConverter
+type columnConverterStmt struct {
+	instrumentedStmt
+}
+
+func (s *columnConverterStmt) ColumnConverter(idx int) driver.ValueConverter {
+	return s.Stmt.(driver.ColumnConverter).ColumnConverter(idx)
+}
+
+// namedValues converts the arguments for drivers without context support, which don't take named arguments
+func namedValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errors.New("gorm:prometheus the driver does not support the use of Named Parameters")
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
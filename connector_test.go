@@ -0,0 +1,304 @@
+package prometheus
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeConnector opens fakeConns, or fails with err
+type fakeConnector struct {
+	err    error
+	closed bool
+}
+
+func (c *fakeConnector) Close() error {
+	c.closed = true
+	return nil
+}
+
+func (c *fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &fakeConn{}, nil
+}
+
+func (c *fakeConnector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return &fakeConn{}, nil
+}
+
+// fakeConn executes any statement without result, but FAIL
+type fakeConn struct{}
+
+func (*fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (*fakeConn) Close() error                        { return nil }
+func (*fakeConn) Begin() (driver.Tx, error)           { return fakeTx{}, nil }
+
+func (*fakeConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	if query == "FAIL" {
+		return nil, errors.New("syntax error")
+	}
+	return driver.RowsAffected(1), nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+func TestConnector(t *testing.T) {
+	p := New(Config{Registry: prometheus.NewRegistry()})
+
+	db := sql.OpenDB(p.Connector(&fakeConnector{}))
+	if err := db.Ping(); err != nil {
+		t.Fatalf("ping failed: %v", err)
+	}
+	if v := testutil.ToFloat64(p.ConnStats.Opened); v != 1 {
+		t.Errorf("expected 1 connection opened, got %v", v)
+	}
+
+	_ = db.Close()
+	if v := testutil.ToFloat64(p.ConnStats.Closed); v != 1 {
+		t.Errorf("expected 1 connection closed, got %v", v)
+	}
+
+	failing := sql.OpenDB(p.Connector(&fakeConnector{err: errors.New("connection refused")}))
+	defer failing.Close()
+	if err := failing.Ping(); err == nil {
+		t.Fatalf("expected the ping to fail")
+	}
+	if v := testutil.ToFloat64(p.ConnStats.Errors); v != 1 {
+		t.Errorf("expected 1 connection error, got %v", v)
+	}
+	if v := testutil.ToFloat64(p.ConnStats.Opened); v != 1 {
+		t.Errorf("expected the failed connection not counted as opened, got %v", v)
+	}
+}
+
+func TestConnectorClose(t *testing.T) {
+	p := New(Config{Registry: prometheus.NewRegistry()})
+
+	connector := &fakeConnector{}
+	if err := sql.OpenDB(p.Connector(connector)).Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if !connector.closed {
+		t.Errorf("expected the wrapped connector to be closed with the DB")
+	}
+}
+
+func TestConnectorBeginTx(t *testing.T) {
+	p := New(Config{Registry: prometheus.NewRegistry()})
+	db := sql.OpenDB(p.Connector(&fakeConnector{}))
+	defer db.Close()
+
+	tx, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("expected a transaction with the default options, got error: %v", err)
+	}
+	_ = tx.Rollback()
+
+	// fakeConn only supports Begin, the options can't be applied
+	for _, opts := range []*sql.TxOptions{{ReadOnly: true}, {Isolation: sql.LevelSerializable}} {
+		if _, err := db.BeginTx(context.Background(), opts); err == nil {
+			t.Errorf("expected an error for the options %+v", opts)
+		}
+	}
+}
+
+func TestConnectorDriverMetrics(t *testing.T) {
+	p := New(Config{Registry: prometheus.NewRegistry(), DriverMetrics: true})
+	db := sql.OpenDB(p.Connector(&fakeConnector{}))
+	defer db.Close()
+
+	if _, err := db.Exec("UPDATE users SET name = 'jinzhu'"); err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+	if _, err := db.Exec("FAIL"); err == nil {
+		t.Fatalf("expected the exec to fail")
+	}
+
+	if v := testutil.ToFloat64(p.ConnStats.Statements.WithLabelValues("exec")); v != 2 {
+		t.Errorf("expected 2 statements, got %v", v)
+	}
+	if v := testutil.ToFloat64(p.ConnStats.StatementErrors.WithLabelValues("exec")); v != 1 {
+		t.Errorf("expected 1 statement error, got %v", v)
+	}
+	if n := histogramOf(t, p.ConnStats.StatementDuration.WithLabelValues("exec")).GetSampleCount(); n != 2 {
+		t.Errorf("expected 2 statement durations, got %d", n)
+	}
+}
+
+// minimalConnector opens the conns returned by open
+type minimalConnector func() driver.Conn
+
+func (c minimalConnector) Connect(context.Context) (driver.Conn, error) { return c(), nil }
+func (c minimalConnector) Driver() driver.Driver                        { return fakeDriver{} }
+
+// minimalConn implements none of the optional driver interfaces, its statements record the args executed
+type minimalConn struct {
+	args []driver.Value
+}
+
+func (c *minimalConn) Prepare(string) (driver.Stmt, error) { return &minimalStmt{conn: c}, nil }
+func (c *minimalConn) Close() error                        { return nil }
+func (c *minimalConn) Begin() (driver.Tx, error)           { return fakeTx{}, nil }
+
+type minimalStmt struct {
+	conn *minimalConn
+}
+
+func (s *minimalStmt) Close() error  { return nil }
+func (s *minimalStmt) NumInput() int { return -1 }
+
+func (s *minimalStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.conn.args = args
+	return driver.RowsAffected(1), nil
+}
+
+func (s *minimalStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+// converterStmt converts its arguments to strings
+type converterStmt struct {
+	minimalStmt
+}
+
+func (s *converterStmt) ColumnConverter(int) driver.ValueConverter { return stringConverter{} }
+
+type stringConverter struct{}
+
+func (stringConverter) ConvertValue(v interface{}) (driver.Value, error) { return fmt.Sprint(v), nil }
+
+// execerConn only executes with the legacy driver.Execer
+type execerConn struct {
+	minimalConn
+	executed string
+}
+
+func (c *execerConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+
+func (c *execerConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	c.executed = query
+	return driver.RowsAffected(1), nil
+}
+
+func TestConnectorMinimalDriver(t *testing.T) {
+	p := New(Config{Registry: prometheus.NewRegistry(), DriverMetrics: true})
+
+	conn := &minimalConn{}
+	wrapped, err := p.Connector(minimalConnector(func() driver.Conn { return conn })).Connect(context.Background())
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+
+	// the wrapped statement only converts the arguments if the driver statement does
+	stmt, err := wrapped.(driver.ConnPrepareContext).PrepareContext(context.Background(), "UPDATE users SET age = ?")
+	if err != nil {
+		t.Fatalf("prepare failed: %v", err)
+	}
+	if _, ok := stmt.(driver.ColumnConverter); ok {
+		t.Errorf("expected no ColumnConverter for a statement without one")
+	}
+
+	// and sql.Conn.Raw gets the driver conn by Unwrap
+	if unwrapped := wrapped.(interface{ Unwrap() driver.Conn }).Unwrap(); unwrapped != conn {
+		t.Errorf("expected the driver conn unwrapped, got %v", unwrapped)
+	}
+
+	db := sql.OpenDB(p.Connector(minimalConnector(func() driver.Conn { return conn })))
+	defer db.Close()
+	if _, err := db.Exec("UPDATE users SET age = ?", int32(18)); err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+	if len(conn.args) != 1 || conn.args[0] != int64(18) {
+		t.Errorf("expected the argument converted by the default converter, got %#v", conn.args)
+	}
+	if v := testutil.ToFloat64(p.ConnStats.Statements.WithLabelValues("exec")); v != 1 {
+		t.Errorf("expected 1 statement, got %v", v)
+	}
+}
+
+// preparedConn prepares stmt
+type preparedConn struct {
+	minimalConn
+	stmt driver.Stmt
+}
+
+func (c *preparedConn) Prepare(string) (driver.Stmt, error) { return c.stmt, nil }
+
+func TestConnectorColumnConverter(t *testing.T) {
+	p := New(Config{Registry: prometheus.NewRegistry(), DriverMetrics: true})
+
+	conn := &preparedConn{}
+	conn.stmt = &converterStmt{minimalStmt{conn: &conn.minimalConn}}
+	db := sql.OpenDB(p.Connector(minimalConnector(func() driver.Conn { return conn })))
+	defer db.Close()
+
+	// the arguments are converted by the ColumnConverter of the statement
+	if _, err := db.Exec("UPDATE users SET age = ?", 18); err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+	if len(conn.args) != 1 || conn.args[0] != "18" {
+		t.Errorf("expected the argument converted by the statement, got %#v", conn.args)
+	}
+}
+
+func TestConnectorLegacyExecer(t *testing.T) {
+	p := New(Config{Registry: prometheus.NewRegistry(), DriverMetrics: true})
+
+	conn := &execerConn{}
+	db := sql.OpenDB(p.Connector(minimalConnector(func() driver.Conn { return conn })))
+	defer db.Close()
+
+	// executed by driver.Execer as without the wrapper, rather than prepared
+	if _, err := db.Exec("DELETE FROM users"); err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+	if conn.executed != "DELETE FROM users" {
+		t.Errorf("expected the statement executed by driver.Execer, got %q", conn.executed)
+	}
+	if v := testutil.ToFloat64(p.ConnStats.Statements.WithLabelValues("exec")); v != 1 {
+		t.Errorf("expected 1 statement, got %v", v)
+	}
+}
+
+// checkerConn accepts any argument, as a string
+type checkerConn struct {
+	minimalConn
+}
+
+func (c *checkerConn) CheckNamedValue(nv *driver.NamedValue) error {
+	nv.Value = fmt.Sprint(nv.Value)
+	return nil
+}
+
+func TestConnectorNamedValueChecker(t *testing.T) {
+	p := New(Config{Registry: prometheus.NewRegistry(), DriverMetrics: true})
+
+	conn := &checkerConn{}
+	db := sql.OpenDB(p.Connector(minimalConnector(func() driver.Conn { return conn })))
+	defer db.Close()
+
+	// a slice isn't a driver.Value, only the checker of the conn accepts it
+	if _, err := db.Exec("UPDATE users SET ids = ?", []int{1, 2}); err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+	if len(conn.args) != 1 || conn.args[0] != "[1 2]" {
+		t.Errorf("expected the argument checked by the conn, got %#v", conn.args)
+	}
+}
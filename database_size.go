@@ -0,0 +1,120 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultDatabaseSizeInterval = 300 // sizes change slowly, refresh every 5 minutes
+
+// DatabaseSize exposes the size of the current database for MySQL and Postgres, detected from the dialector
+type DatabaseSize struct {
+	Prefix   string
+	Interval uint32 // default 300 seconds
+	PerTable bool   // if true, also expose the size per table (MySQL only), one series per table
+
+	Registerer prometheus.Registerer // registers the metrics, default the plugin registerer
+	size       prometheus.Gauge
+	tables     *prometheus.GaugeVec
+	once       sync.Once
+	metricsResult
+}
+
+func (m *DatabaseSize) Metrics(p *Prometheus) []prometheus.Collector {
+	if m.Interval == 0 {
+		m.Interval = defaultDatabaseSizeInterval
+	}
+
+	m.once.Do(func() {
+		m.size = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        p.statusName(m.Prefix, "database_size_bytes"),
+			Help:        p.help(p.statusName(m.Prefix, "database_size_bytes"), "Size of the current database, in bytes."),
+			ConstLabels: p.collectorLabels(m),
+		})
+		_ = p.registerer(m.Registerer).Register(m.size)
+
+		if m.PerTable {
+			m.tables = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name:        p.statusName(m.Prefix, "table_size_bytes"),
+				Help:        p.help(p.statusName(m.Prefix, "table_size_bytes"), "Size of the table including indexes, in bytes."),
+				ConstLabels: p.collectorLabels(m),
+			}, p.transformLabelNames([]string{"table"}))
+			_ = p.registerer(m.Registerer).Register(m.tables)
+		}
+	})
+
+	m.metricsErr = m.collect(p)
+
+	if m.tables != nil {
+		return []prometheus.Collector{m.size, m.tables}
+	}
+	return []prometheus.Collector{m.size}
+}
+
+func (m *DatabaseSize) RefreshInterval() uint32 {
+	return m.Interval
+}
+
+func (m *DatabaseSize) Name() string {
+	return "database_size"
+}
+
+func (m *DatabaseSize) Refresh(p *Prometheus) error {
+	return m.collect(p)
+}
+
+func (m *DatabaseSize) collect(p *Prometheus) error {
+	switch p.DB.Dialector.Name() {
+	case "mysql":
+		return m.collectMySQL(p)
+	case "postgres":
+		return m.collectPostgres(p)
+	default:
+		err := fmt.Errorf("database size is not supported for %s", p.DB.Dialector.Name())
+		p.DB.Logger.Error(context.Background(), "gorm:prometheus %v", err)
+		return err
+	}
+}
+
+func (m *DatabaseSize) collectMySQL(p *Prometheus) error {
+	rows, err := p.statusDB().Raw("SELECT table_name, COALESCE(data_length + index_length, 0) FROM information_schema.tables WHERE table_schema = DATABASE()").Rows()
+	if err != nil {
+		p.DB.Logger.Error(context.Background(), "gorm:prometheus query error: %v", err)
+		return err
+	}
+	defer rows.Close()
+
+	var (
+		total     float64
+		tableName string
+		tableSize float64
+	)
+	for rows.Next() {
+		if err = rows.Scan(&tableName, &tableSize); err != nil {
+			p.DB.Logger.Error(context.Background(), "gorm:prometheus scan got error: %v", err)
+			continue
+		}
+
+		total += tableSize
+		if m.tables != nil {
+			m.tables.WithLabelValues(tableName).Set(tableSize)
+		}
+	}
+
+	m.size.Set(total)
+	return nil
+}
+
+func (m *DatabaseSize) collectPostgres(p *Prometheus) error {
+	var size float64
+	if err := p.statusDB().Raw("SELECT pg_database_size(current_database())").Row().Scan(&size); err != nil {
+		p.DB.Logger.Error(context.Background(), "gorm:prometheus query error: %v", err)
+		return err
+	}
+
+	m.size.Set(size)
+	return nil
+}
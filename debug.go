@@ -0,0 +1,42 @@
+package prometheus
+
+import (
+	"sync"
+	"time"
+)
+
+// debugState is the internal state of the plugin dumped by the debug handler
+type debugState struct {
+	lock        sync.Mutex
+	refreshes   uint64
+	lastRefresh time.Time
+	errors      map[string]debugError // last error per source, e.g. push or a collector type
+}
+
+type debugError struct {
+	err string
+	at  time.Time
+}
+
+func (p *Prometheus) recordRefresh() {
+	p.debug.lock.Lock()
+	defer p.debug.lock.Unlock()
+	p.debug.refreshes++
+	p.debug.lastRefresh = time.Now()
+}
+
+// lastRefresh returns the time of the last DBStats refresh, zero before the first one
+func (p *Prometheus) lastRefresh() time.Time {
+	p.debug.lock.Lock()
+	defer p.debug.lock.Unlock()
+	return p.debug.lastRefresh
+}
+
+func (p *Prometheus) recordError(source string, err error) {
+	p.debug.lock.Lock()
+	defer p.debug.lock.Unlock()
+	if p.debug.errors == nil {
+		p.debug.errors = map[string]debugError{}
+	}
+	p.debug.errors[source] = debugError{err: err.Error(), at: time.Now()}
+}
@@ -0,0 +1,105 @@
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+const explainTimeout = 5 * time.Second
+
+var defaultPlanCostBuckets = prometheus.ExponentialBuckets(1, 10, 8) // 1 to 10^7, in planner cost units
+
+// explainPrefixes are the EXPLAIN statements reporting the estimated cost as JSON, by dialector
+var explainPrefixes = map[string]string{
+	"postgres": "EXPLAIN (FORMAT JSON) ",
+	"mysql":    "EXPLAIN FORMAT=JSON ",
+}
+
+func newPlanCost(labels map[string]string, config *Config) prometheus.Histogram {
+	return prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:        config.metricName("", "query_plan_cost"),
+		Help:        config.help(config.metricName("", "query_plan_cost"), "Estimated cost of the plan of the sampled queries, in planner cost units."),
+		ConstLabels: labels,
+		Buckets:     defaultPlanCostBuckets,
+	})
+}
+
+// sampleExplain explains a sample of the queries in the background and records the estimated cost of their plan,
+// at most one EXPLAIN runs at a time, the queries sampled meanwhile or in a transaction are skipped
+func (stats *QueryStats) sampleExplain(db *gorm.DB) {
+	if rand.Float64() >= stats.config.ExplainSampleRate {
+		return
+	}
+
+	dialect := db.Dialector.Name()
+	prefix, ok := explainPrefixes[dialect]
+	if !ok {
+		return
+	}
+
+	// the EXPLAIN of a query in a transaction would run on another connection, which can't see its uncommitted
+	// or temporary objects
+	if _, ok := db.Statement.ConnPool.(gorm.TxCommitter); ok {
+		return
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil || !atomic.CompareAndSwapInt32(&stats.explaining, 0, 1) {
+		return
+	}
+
+	query, vars := prefix+db.Statement.SQL.String(), append([]interface{}(nil), db.Statement.Vars...)
+	go func() {
+		defer atomic.StoreInt32(&stats.explaining, 0)
+
+		ctx, cancel := context.WithTimeout(context.Background(), explainTimeout)
+		defer cancel()
+
+		var plan []byte
+		if err := sqlDB.QueryRowContext(ctx, query, vars...).Scan(&plan); err != nil {
+			db.Logger.Warn(ctx, "gorm:prometheus explain got error: %v", err)
+			return
+		}
+
+		if cost, ok := planCost(dialect, plan); ok {
+			stats.PlanCost.Observe(cost)
+		}
+	}()
+}
+
+// planCost returns the estimated total cost of the JSON plan
+func planCost(dialect string, plan []byte) (float64, bool) {
+	switch dialect {
+	case "postgres":
+		var plans []struct {
+			Plan struct {
+				TotalCost float64 `json:"Total Cost"`
+			}
+		}
+		if err := json.Unmarshal(plan, &plans); err != nil || len(plans) == 0 {
+			return 0, false
+		}
+		return plans[0].Plan.TotalCost, true
+	case "mysql":
+		var explain struct {
+			QueryBlock struct {
+				CostInfo struct {
+					QueryCost string `json:"query_cost"`
+				} `json:"cost_info"`
+			} `json:"query_block"`
+		}
+		if err := json.Unmarshal(plan, &explain); err != nil {
+			return 0, false
+		}
+		cost, err := strconv.ParseFloat(explain.QueryBlock.CostInfo.QueryCost, 64)
+		return cost, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,117 @@
+package prometheus
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestPlanCost(t *testing.T) {
+	for _, tt := range []struct {
+		dialect, plan string
+		cost          float64
+		ok            bool
+	}{
+		{"mysql", `{"query_block": {"select_id": 1, "cost_info": {"query_cost": "12.50"}}}`, 12.5, true},
+		{"postgres", `[{"Plan": {"Node Type": "Seq Scan", "Startup Cost": 0.00, "Total Cost": 35.50}}]`, 35.5, true},
+		{"mysql", `not json`, 0, false},
+		{"sqlite", `{}`, 0, false},
+	} {
+		if cost, ok := planCost(tt.dialect, []byte(tt.plan)); cost != tt.cost || ok != tt.ok {
+			t.Errorf("expected %v %v for %s plan %s, got %v %v", tt.cost, tt.ok, tt.dialect, tt.plan, cost, ok)
+		}
+	}
+}
+
+// postgresDialector names the dummy dialector postgres, for the queries to be explained
+type postgresDialector struct {
+	tests.DummyDialector
+}
+
+func (postgresDialector) Name() string {
+	return "postgres"
+}
+
+// warnLogger records the warnings, e.g. of the EXPLAIN
+type warnLogger struct {
+	logger.Interface
+	lock     sync.Mutex
+	warnings []string
+}
+
+func (l *warnLogger) Warn(_ context.Context, msg string, _ ...interface{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.warnings = append(l.warnings, msg)
+}
+
+type explainUser struct {
+	ID   uint
+	Name string
+}
+
+func TestSampleExplain(t *testing.T) {
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock, got error: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	warnings := &warnLogger{Interface: logger.Discard}
+	db, err := gorm.Open(postgresDialector{}, &gorm.Config{ConnPool: sqlDB, Logger: warnings, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatalf("failed to open gorm, got error: %v", err)
+	}
+	p := usePlugin(t, db, Config{CallbackMetrics: true, ExplainSampleRate: 1})
+
+	// waitExplained waits for the EXPLAIN in progress, started synchronously by the query
+	waitExplained := func() {
+		for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt32(&p.QueryStats.explaining) != 0; {
+			if time.Now().After(deadline) {
+				t.Fatalf("the EXPLAIN didn't complete")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "jinzhu"))
+	mock.ExpectQuery("EXPLAIN").WillReturnRows(sqlmock.NewRows([]string{"QUERY PLAN"}).AddRow(`[{"Plan": {"Total Cost": 35.5}}]`))
+	var users []explainUser
+	if err := db.Find(&users).Error; err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	waitExplained()
+	if n := histogramOf(t, p.QueryStats.PlanCost).GetSampleCount(); n != 1 {
+		t.Fatalf("expected the query explained, got %d plan costs", n)
+	}
+
+	// the queries in a transaction aren't explained
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "jinzhu"))
+	mock.ExpectCommit()
+	tx := db.Begin()
+	if err := tx.Find(&users).Error; err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	waitExplained()
+	if err := tx.Commit().Error; err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+
+	if n := histogramOf(t, p.QueryStats.PlanCost).GetSampleCount(); n != 1 {
+		t.Errorf("expected the query in the transaction not explained, got %d plan costs", n)
+	}
+	if len(warnings.warnings) > 0 {
+		t.Errorf("expected no EXPLAIN in the transaction, got %v", warnings.warnings)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
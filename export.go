@@ -0,0 +1,39 @@
+package prometheus
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Exporter pushes the gathered metrics to another backend than the Pushgateway, e.g. OTLPExporter to an
+// OpenTelemetry collector, it's called every ExportInterval
+type Exporter interface {
+	Export(context.Context, prometheus.Gatherer) error
+}
+
+// ExporterFunc adapts a function to an Exporter
+type ExporterFunc func(context.Context, prometheus.Gatherer) error
+
+func (f ExporterFunc) Export(ctx context.Context, gatherer prometheus.Gatherer) error {
+	return f(ctx, gatherer)
+}
+
+func (p *Prometheus) startExport() {
+	interval := p.Config.ExportInterval
+	if interval == 0 {
+		interval = p.Config.RefreshInterval
+	}
+
+	gatherer := p.Gatherer()
+	if p.Config.PushGatherer != nil {
+		gatherer = p.Config.PushGatherer
+	}
+
+	p.every(interval, func() {
+		if err := p.Config.Exporter.Export(context.Background(), gatherer); err != nil {
+			p.DB.Logger.Error(context.Background(), "gorm:prometheus export failed: %v", err)
+			p.recordError("export", err)
+		}
+	})
+}
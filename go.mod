@@ -3,6 +3,8 @@ module gorm.io/plugin/prometheus
 go 1.14
 
 require (
+	github.com/DATA-DOG/go-sqlmock v1.5.2
 	github.com/prometheus/client_golang v1.20.5
+	github.com/prometheus/client_model v0.6.1
 	gorm.io/gorm v1.25.0
 )
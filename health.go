@@ -0,0 +1,125 @@
+package prometheus
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// healthState tells whether the plugin is fully wired: its version, the callbacks registered and the collectors refreshing
+type healthState struct {
+	buildInfo   *prometheus.GaugeVec
+	callbacks   *prometheus.GaugeVec
+	collectorUp *prometheus.GaugeVec
+	info        prometheus.Gauge // with Info only
+}
+
+func newHealthState(labels map[string]string, config *Config) *healthState {
+	state := &healthState{
+		buildInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        config.metricName("prometheus", "build_info"),
+			Help:        config.help(config.metricName("prometheus", "build_info"), "Versions of the plugin and of gorm, always 1."),
+			ConstLabels: labels,
+		}, config.transformLabelNames([]string{"version", "gorm_version"})),
+		callbacks: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        config.metricName("prometheus", "callbacks_registered"),
+			Help:        config.help(config.metricName("prometheus", "callbacks_registered"), "Whether the query metrics callbacks of the operation are registered, 1 per operation."),
+			ConstLabels: labels,
+		}, config.transformLabelNames([]string{"operation"})),
+		collectorUp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        config.metricName("prometheus", "collector_up"),
+			Help:        config.help(config.metricName("prometheus", "collector_up"), "Whether the last refresh of the MetricsCollector succeeded, 1 or 0."),
+			ConstLabels: labels,
+		}, config.transformLabelNames([]string{"collector"})),
+	}
+
+	version, gormVersion := moduleVersions()
+	state.buildInfo.With(config.transformLabels(map[string]string{"version": version, "gorm_version": gormVersion})).Set(1)
+
+	if len(config.Info) > 0 {
+		infoLabels := map[string]string{}
+		for k, v := range config.transformLabels(config.Info) {
+			infoLabels[k] = v
+		}
+		for k, v := range labels { // the plugin labels take precedence, e.g. db_name
+			infoLabels[k] = v
+		}
+
+		state.info = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        config.metricName("prometheus", "info"),
+			Help:        config.help(config.metricName("prometheus", "info"), "Metadata of the instance configured by Info, always 1."),
+			ConstLabels: infoLabels,
+		})
+		state.info.Set(1)
+	}
+	return state
+}
+
+func (state *healthState) Collectors() []prometheus.Collector {
+	collectors := []prometheus.Collector{state.buildInfo, state.callbacks, state.collectorUp}
+	if state.info != nil {
+		collectors = append(collectors, state.info)
+	}
+	return collectors
+}
+
+// moduleVersions returns the versions of the plugin and gorm modules built into the binary, "unknown" if not found
+func moduleVersions() (version, gormVersion string) {
+	version, gormVersion = "unknown", "unknown"
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+
+	for _, module := range append([]*debug.Module{&info.Main}, info.Deps...) {
+		switch module.Path {
+		case "gorm.io/plugin/prometheus":
+			version = moduleVersion(module)
+		case "gorm.io/gorm":
+			gormVersion = moduleVersion(module)
+		}
+	}
+	return
+}
+
+func moduleVersion(module *debug.Module) string {
+	if module.Replace != nil {
+		module = module.Replace
+	}
+	if module.Version == "" { // replaced by a directory
+		return "(devel)"
+	}
+	return module.Version
+}
+
+// callbacksRegistered records the operations whose callbacks are registered
+func (state *healthState) callbacksRegistered(config *Config, operations []string) {
+	for _, operation := range operations {
+		state.callbacks.With(config.transformLabels(map[string]string{"operation": operation})).Set(1)
+	}
+}
+
+// refreshed records the result of the refresh of the MetricsCollector
+func (state *healthState) refreshed(config *Config, mc interface{}, err error) {
+	name := fmt.Sprintf("%T", mc)
+	if named, ok := mc.(Named); ok {
+		name = named.Name()
+	}
+
+	up := 1.0
+	if err != nil {
+		up = 0
+	}
+	state.collectorUp.With(config.transformLabels(map[string]string{"collector": name})).Set(up)
+}
+
+// metricsResult is embedded by the collectors to report the error of the collect done by their Metrics
+type metricsResult struct {
+	metricsErr error
+}
+
+func (r *metricsResult) metricsError() error {
+	return r.metricsErr
+}
@@ -0,0 +1,54 @@
+package prometheus
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gorm.io/gorm"
+)
+
+func TestInfo(t *testing.T) {
+	config := &Config{Info: map[string]string{"team": "payments", "db_name": "ignored"}}
+	state := newHealthState(map[string]string{"db_name": "db1"}, config)
+
+	expected := `
+# HELP gorm_prometheus_info Metadata of the instance configured by Info, always 1.
+# TYPE gorm_prometheus_info gauge
+gorm_prometheus_info{db_name="db1",team="payments"} 1
+`
+	if err := testutil.CollectAndCompare(state.info, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+	if config.Info["db_name"] != "ignored" {
+		t.Errorf("Info must not be modified")
+	}
+}
+
+func TestCollectorUpMetricsError(t *testing.T) {
+	db, mock := newMockGorm(t, &gorm.Config{})
+	p := New(Config{})
+	p.DB = db
+	p.health = newHealthState(nil, p.Config)
+
+	mock.ExpectQuery("SHOW ENGINE INNODB STATUS").WillReturnError(errors.New("access denied"))
+	p.safeMetrics(&InnoDB{Prefix: "test_up_innodb_"})
+
+	// no expectations left, every query of the Postgres collector fails
+	postgres := &Postgres{Prefix: "test_up_postgres_"}
+	p.safeMetrics(postgres)
+
+	expected := `
+# HELP gorm_prometheus_collector_up Whether the last refresh of the MetricsCollector succeeded, 1 or 0.
+# TYPE gorm_prometheus_collector_up gauge
+gorm_prometheus_collector_up{collector="innodb"} 0
+gorm_prometheus_collector_up{collector="postgres"} 0
+`
+	if err := testutil.CollectAndCompare(p.health.collectorUp, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+	if err := postgres.Refresh(p); err == nil {
+		t.Errorf("expected the query error from the Postgres refresh")
+	}
+}
@@ -0,0 +1,156 @@
+package prometheus
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// innoDBFields are the gauges parsed from SHOW ENGINE INNODB STATUS, the output varies between versions and
+// forks, a field missing from the output isn't updated
+var innoDBFields = []struct {
+	name  string
+	help  string
+	parse func(status string) (float64, bool)
+}{
+	{"innodb_history_list_length", "Number of undo log entries not purged yet.", innoDBNumber(`History list length (\d+)`)},
+	{"innodb_pending_aio_reads", "Number of pending normal asynchronous reads.", innoDBNumbers(`Pending normal aio reads:\s*(\[[\d,\s]*\]|\d+)`)},
+	{"innodb_pending_aio_writes", "Number of pending normal asynchronous writes.", innoDBNumbers(`aio writes:\s*(\[[\d,\s]*\]|\d+)`)},
+	{"innodb_pending_log_flushes", "Number of pending log file flushes.", innoDBNumber(`Pending flushes \(fsync\) log:\s*(\d+)`)},
+	{"innodb_lock_wait_transactions", "Number of transactions waiting for a row lock.", innoDBLockWaits},
+}
+
+// InnoDB exposes a few InnoDB health fields that aren't status variables, parsed from SHOW ENGINE INNODB STATUS
+type InnoDB struct {
+	Prefix     string
+	Interval   uint32
+	Registerer prometheus.Registerer // registers the metrics, default the plugin registerer
+	gauges     map[string]prometheus.Gauge
+	once       sync.Once
+	metricsResult
+}
+
+func (m *InnoDB) Metrics(p *Prometheus) []prometheus.Collector {
+	if m.Interval == 0 {
+		m.Interval = p.RefreshInterval
+	}
+
+	m.once.Do(func() {
+		m.gauges = make(map[string]prometheus.Gauge, len(innoDBFields))
+		for _, field := range innoDBFields {
+			gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+				Name:        p.statusName(m.Prefix, field.name),
+				Help:        p.help(p.statusName(m.Prefix, field.name), field.help),
+				ConstLabels: p.collectorLabels(m),
+			})
+			m.gauges[field.name] = gauge
+			_ = p.registerer(m.Registerer).Register(gauge)
+		}
+	})
+
+	m.metricsErr = m.collect(p)
+
+	collectors := make([]prometheus.Collector, 0, len(m.gauges))
+	for _, field := range innoDBFields {
+		collectors = append(collectors, m.gauges[field.name])
+	}
+	return collectors
+}
+
+func (m *InnoDB) RefreshInterval() uint32 {
+	return m.Interval
+}
+
+func (m *InnoDB) Name() string {
+	return "innodb"
+}
+
+func (m *InnoDB) Refresh(p *Prometheus) error {
+	return m.collect(p)
+}
+
+func (m *InnoDB) collect(p *Prometheus) error {
+	db, err := p.statusSQLDB()
+	if err != nil {
+		p.DB.Logger.Error(context.Background(), "gorm:prometheus failed to get db, got error: %v", err)
+		return err
+	}
+
+	var engineType, name, status string
+	if err = db.QueryRow("SHOW ENGINE INNODB STATUS").Scan(&engineType, &name, &status); err != nil {
+		p.DB.Logger.Error(context.Background(), "gorm:prometheus query error: %v", err)
+		return err
+	}
+
+	for _, field := range innoDBFields {
+		if value, ok := field.parse(status); ok {
+			m.gauges[field.name].Set(value)
+		}
+	}
+	return nil
+}
+
+// innoDBNumber parses the number captured by expr
+func innoDBNumber(expr string) func(string) (float64, bool) {
+	re := regexp.MustCompile(expr)
+	return func(status string) (float64, bool) {
+		match := re.FindStringSubmatch(status)
+		if match == nil {
+			return 0, false
+		}
+
+		value, err := strconv.ParseFloat(match[1], 64)
+		return value, err == nil
+	}
+}
+
+// innoDBNumbers parses the number captured by expr, or the sum of a list like [0, 1, 0, 0], one per I/O thread
+// in recent versions
+func innoDBNumbers(expr string) func(string) (float64, bool) {
+	re := regexp.MustCompile(expr)
+	return func(status string) (float64, bool) {
+		match := re.FindStringSubmatch(status)
+		if match == nil {
+			return 0, false
+		}
+
+		var sum float64
+		for _, n := range strings.FieldsFunc(strings.Trim(match[1], "[]"), func(r rune) bool { return r == ',' || r == ' ' }) {
+			value, err := strconv.ParseFloat(n, 64)
+			if err != nil {
+				return 0, false
+			}
+			sum += value
+		}
+		return sum, true
+	}
+}
+
+// innoDBLockWaits counts the transactions in LOCK WAIT state of the TRANSACTIONS section, a transaction is listed
+// from its ---TRANSACTION header, the LOCK WAIT line follows on one of the next lines
+func innoDBLockWaits(status string) (float64, bool) {
+	start := strings.Index(status, "\nTRANSACTIONS\n")
+	if start < 0 {
+		return 0, false
+	}
+
+	var (
+		waits   float64
+		inTrx   bool // the line belongs to a transaction
+		waiting bool // the transaction is already counted
+	)
+	for _, line := range strings.Split(status[start:], "\n") {
+		switch {
+		case strings.HasPrefix(line, "---TRANSACTION "):
+			inTrx, waiting = true, false
+		case inTrx && !waiting && strings.HasPrefix(line, "LOCK WAIT"):
+			waiting = true
+			waits++
+		}
+	}
+	return waits, true
+}
@@ -0,0 +1,69 @@
+package prometheus
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInnoDB(t *testing.T) {
+	p, mock := newMockPrometheus(t)
+	status := `
+=====================================
+2024-01-01 10:00:00 0x7f INNODB MONITOR OUTPUT
+=====================================
+------------
+TRANSACTIONS
+------------
+Trx id counter 1234
+Purge done for trx's n:o < 1200 undo n:o < 0 state: running but idle
+History list length 42
+LIST OF TRANSACTIONS FOR EACH SESSION:
+---TRANSACTION 421937869386840, not started
+0 lock struct(s), heap size 1128, 0 row lock(s)
+---TRANSACTION 1233, ACTIVE 9 sec starting index read
+mysql tables in use 1, locked 1
+LOCK WAIT 2 lock struct(s), heap size 1128, 1 row lock(s)
+MySQL thread id 10, OS thread handle 139871, query id 95 localhost root updating
+update orders set status = 'paid' where id = 1
+------- TRX HAS BEEN WAITING 9 SEC FOR THIS LOCK TO BE GRANTED:
+RECORD LOCKS space id 2 page no 4 n bits 72 index PRIMARY of table ` + "`shop`.`orders`" + ` trx id 1233 lock_mode X locks rec but not gap waiting
+Record lock, heap no 2 PHYSICAL RECORD: n_fields 3; compact format; info bits 0
+ 0: len 4; hex 80000001; asc     ;;
+------------------
+---TRANSACTION 1232, ACTIVE 20 sec
+2 lock struct(s), heap size 1128, 1 row lock(s), undo log entries 1
+MySQL thread id 9, OS thread handle 139872, query id 93 localhost root
+---TRANSACTION 1231, ACTIVE 4 sec starting index read
+mysql tables in use 1, locked 1
+LOCK WAIT 2 lock struct(s), heap size 1128, 1 row lock(s)
+MySQL thread id 11, OS thread handle 139873, query id 97 localhost root updating
+delete from orders where id = 1
+------- TRX HAS BEEN WAITING 4 SEC FOR THIS LOCK TO BE GRANTED:
+RECORD LOCKS space id 2 page no 4 n bits 72 index PRIMARY of table ` + "`shop`.`orders`" + ` trx id 1231 lock_mode X locks rec but not gap waiting
+------------------
+--------
+FILE I/O
+--------
+Pending normal aio reads: [1, 0, 2, 0] , aio writes: [0, 0, 0, 3] ,
+ ibuf aio reads:, log i/o's:, sync i/o's:
+Pending flushes (fsync) log: 1; buffer pool: 0
+`
+	mock.ExpectQuery("SHOW ENGINE INNODB STATUS").WillReturnRows(sqlmock.NewRows([]string{"Type", "Name", "Status"}).AddRow("InnoDB", "", status))
+
+	m := &InnoDB{Prefix: "test_innodb_"}
+	m.Metrics(p)
+
+	for name, expected := range map[string]float64{
+		"innodb_history_list_length":    42,
+		"innodb_pending_aio_reads":      3,
+		"innodb_pending_aio_writes":     3,
+		"innodb_pending_log_flushes":    1,
+		"innodb_lock_wait_transactions": 2,
+	} {
+		if v := testutil.ToFloat64(m.gauges[name]); v != expected {
+			t.Errorf("expected %s %v, got %v", name, expected, v)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+//go:build !gorm_prometheus_noserver
+// +build !gorm_prometheus_noserver
+
+package prometheus
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// jsonMetricFamily is the JSON snapshot of a metric family, histograms and summaries only report their count and sum
+type jsonMetricFamily struct {
+	Name    string       `json:"name"`
+	Help    string       `json:"help"`
+	Type    string       `json:"type"`
+	Metrics []jsonMetric `json:"metrics"`
+}
+
+type jsonMetric struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  *jsonFloat        `json:"value,omitempty"`
+	Count  *uint64           `json:"count,omitempty"`
+	Sum    *jsonFloat        `json:"sum,omitempty"`
+}
+
+// jsonFloat encodes the values JSON can't represent, NaN and infinities, as null, e.g. the gauges marked stale
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+		return []byte("null"), nil
+	}
+	return json.Marshal(float64(f))
+}
+
+// jsonHandler serves the metrics gathered for the Prometheus endpoint as JSON
+func (p *Prometheus) jsonHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mfs, err := p.Gatherer().Gather()
+		if err != nil && len(mfs) == 0 {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		families := make([]jsonMetricFamily, 0, len(mfs))
+		for _, mf := range mfs {
+			family := jsonMetricFamily{Name: mf.GetName(), Help: mf.GetHelp(), Type: strings.ToLower(mf.GetType().String())}
+			for _, m := range mf.GetMetric() {
+				family.Metrics = append(family.Metrics, newJSONMetric(m))
+			}
+			families = append(families, family)
+		}
+
+		// encoded before writing, so an error is still reported with its status
+		var body bytes.Buffer
+		if err = json.NewEncoder(&body).Encode(families); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body.Bytes())
+	})
+}
+
+func newJSONMetric(m *dto.Metric) jsonMetric {
+	metric := jsonMetric{}
+	if len(m.GetLabel()) > 0 {
+		metric.Labels = make(map[string]string, len(m.GetLabel()))
+		for _, label := range m.GetLabel() {
+			metric.Labels[label.GetName()] = label.GetValue()
+		}
+	}
+
+	switch {
+	case m.Counter != nil:
+		metric.Value = (*jsonFloat)(m.Counter.Value)
+	case m.Gauge != nil:
+		metric.Value = (*jsonFloat)(m.Gauge.Value)
+	case m.Untyped != nil:
+		metric.Value = (*jsonFloat)(m.Untyped.Value)
+	case m.Histogram != nil:
+		metric.Count, metric.Sum = m.Histogram.SampleCount, (*jsonFloat)(m.Histogram.SampleSum)
+	case m.Summary != nil:
+		metric.Count, metric.Sum = m.Summary.SampleCount, (*jsonFloat)(m.Summary.SampleSum)
+	}
+	return metric
+}
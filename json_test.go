@@ -0,0 +1,36 @@
+//go:build !gorm_prometheus_noserver
+// +build !gorm_prometheus_noserver
+
+package prometheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestJSONHandler(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	p := New(Config{Registry: registry})
+
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_json_open", Help: "Open connections."})
+	gauge.Set(3)
+	stale := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_json_stale", Help: "Stale gauge."})
+	markStale([]prometheus.Collector{stale})
+	registry.MustRegister(gauge, stale)
+
+	w := httptest.NewRecorder()
+	p.jsonHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+	}
+
+	expected := `[{"name":"test_json_open","help":"Open connections.","type":"gauge","metrics":[{"value":3}]},` +
+		`{"name":"test_json_stale","help":"Stale gauge.","type":"gauge","metrics":[{"value":null}]}]`
+	if body := strings.TrimSpace(w.Body.String()); body != expected {
+		t.Errorf("expected %s, got %s", expected, body)
+	}
+}
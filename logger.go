@@ -0,0 +1,32 @@
+//go:build !gorm_prometheus_noserver
+// +build !gorm_prometheus_noserver
+
+package prometheus
+
+import (
+	"context"
+	"log"
+	"strings"
+)
+
+// gormLogWriter forwards the lines written by a standard logger to the GORM logger
+type gormLogWriter struct {
+	p *Prometheus
+}
+
+func (w gormLogWriter) Write(b []byte) (int, error) {
+	if w.p.DB == nil { // the handler may serve before the plugin is initialized
+		log.Print(string(b))
+		return len(b), nil
+	}
+	w.p.DB.Logger.Error(context.Background(), "gorm:prometheus %s", strings.TrimSuffix(string(b), "\n"))
+	return len(b), nil
+}
+
+// errorLog returns the logger of the http server and metrics handler errors
+func (p *Prometheus) errorLog() *log.Logger {
+	if p.Config.ServerErrorLog != nil {
+		return p.Config.ServerErrorLog
+	}
+	return log.New(gormLogWriter{p: p}, "", 0)
+}
@@ -0,0 +1,47 @@
+package prometheus
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MariaDB collects status variables like MySQL, additionally exposing the ON/OFF and YES/NO values MariaDB
+// reports for e.g. Galera wsrep_* and Aria variables as 1/0. The server is detected from its version string, each
+// of the Hosts is detected on its own, other servers are collected exactly as by MySQL
+type MariaDB struct {
+	MySQL
+}
+
+func (m *MariaDB) Metrics(p *Prometheus) []prometheus.Collector {
+	m.MySQL.detectMariaDB = true
+	if db, err := p.statusSQLDB(); err != nil {
+		p.DB.Logger.Error(context.Background(), "gorm:prometheus failed to get db, got error: %v", err)
+	} else {
+		m.MySQL.mariaDB = isMariaDB(p, db)
+	}
+	return m.MySQL.Metrics(p)
+}
+
+// isMariaDB detects a MariaDB server from its version string
+func isMariaDB(p *Prometheus, db *sql.DB) bool {
+	var version string
+	if err := db.QueryRow("SELECT VERSION()").Scan(&version); err != nil {
+		p.DB.Logger.Error(context.Background(), "gorm:prometheus query error: %v", err)
+		return false
+	}
+	return strings.Contains(strings.ToLower(version), "mariadb")
+}
+
+// mariaDBValue converts the boolean values of MariaDB status variables
+func mariaDBValue(value string) (float64, bool) {
+	switch strings.ToUpper(value) {
+	case "ON", "YES":
+		return 1, true
+	case "OFF", "NO":
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,62 @@
+package prometheus
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMariaDB(t *testing.T) {
+	p, mock := newMockPrometheus(t)
+	mock.ExpectQuery("SELECT VERSION()").WillReturnRows(sqlmock.NewRows([]string{"VERSION()"}).AddRow("10.11.6-MariaDB-log"))
+	mock.ExpectQuery("SHOW STATUS").WillReturnRows(statusRows(
+		"Threads_running", "3",
+		"wsrep_ready", "ON",
+		"wsrep_connected", "OFF",
+		"wsrep_cluster_status", "Primary",
+	))
+
+	m := &MariaDB{MySQL{Prefix: "test_mariadb_"}}
+	if collectors := m.Metrics(p); len(collectors) != 4 {
+		t.Fatalf("expected 4 collectors, got %d", len(collectors))
+	}
+
+	if v := testutil.ToFloat64(m.status["wsrep_ready"]); v != 1 {
+		t.Errorf("expected wsrep_ready 1, got %v", v)
+	}
+
+	if v := testutil.ToFloat64(m.status["wsrep_connected"]); v != 0 {
+		t.Errorf("expected wsrep_connected 0, got %v", v)
+	}
+}
+
+func TestMariaDBHosts(t *testing.T) {
+	p, mock := newMockPrometheus(t)
+	mock.ExpectQuery("SELECT VERSION()").WillReturnRows(sqlmock.NewRows([]string{"VERSION()"}).AddRow("8.0.36"))
+
+	// the nodes of a cluster are detected on their own, e.g. while migrating from MySQL to MariaDB
+	hosts := map[string]*sql.DB{}
+	for key, version := range map[string]string{"mariadb": "10.11.6-MariaDB-log", "mysql": "8.0.36"} {
+		db, hostMock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("failed to create sqlmock, got error: %v", err)
+		}
+		t.Cleanup(func() { _ = db.Close() })
+		hostMock.ExpectQuery("SELECT VERSION()").WillReturnRows(sqlmock.NewRows([]string{"VERSION()"}).AddRow(version))
+		hostMock.ExpectQuery("SHOW STATUS").WillReturnRows(statusRows("wsrep_ready", "ON"))
+		hosts[key] = db
+	}
+
+	m := &MariaDB{MySQL{Prefix: "test_mariadb_hosts_", Hosts: hosts, Registerer: prometheus.NewRegistry()}}
+	m.Metrics(p)
+
+	if v := testutil.ToFloat64(m.hosts["mariadb"].status["wsrep_ready"]); v != 1 {
+		t.Errorf("expected wsrep_ready 1 on the MariaDB host, got %v", v)
+	}
+	if _, ok := m.hosts["mysql"].status["wsrep_ready"]; ok {
+		t.Errorf("expected ON not converted on the MySQL host")
+	}
+}
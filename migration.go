@@ -0,0 +1,30 @@
+package prometheus
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Migrate runs the migration fn and records how long it took under name, e.g.
+//
+//	err := p.Migrate("schema", func() error {
+//		return db.AutoMigrate(&User{}, &Order{})
+//	})
+//
+// The duration is recorded whether the migration succeeded or not, the error of fn is returned.
+func (p *Prometheus) Migrate(name string, fn func() error) error {
+	p.migrationOnce.Do(func() {
+		p.migrationDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        p.metricName("", "migration_duration_seconds"),
+			Help:        p.help(p.metricName("", "migration_duration_seconds"), "Time spent in the last run of the migration, in seconds."),
+			ConstLabels: p.Labels,
+		}, p.transformLabelNames([]string{"migration"}))
+		p.register(p.migrationDuration)
+	})
+
+	startedAt := time.Now()
+	err := fn()
+	p.migrationDuration.With(p.transformLabels(map[string]string{"migration": name})).Set(time.Since(startedAt).Seconds())
+	return err
+}
@@ -0,0 +1,106 @@
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+type ModelStats struct {
+	Operations *prometheus.CounterVec // The total number of operations on the models of RegisterModelMetrics, by table and operation.
+
+	config *Config
+	lock   sync.RWMutex
+	tables map[string]bool // tables of the registered models
+}
+
+func newModelStats(labels map[string]string, config *Config) *ModelStats {
+	return &ModelStats{
+		Operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        config.metricName("", "model_operations_total"),
+			Help:        config.help(config.metricName("", "model_operations_total"), "The total number of operations on the models of RegisterModelMetrics, by table and operation."),
+			ConstLabels: labels,
+		}, config.transformLabelNames([]string{"table", "operation"})),
+		config: config,
+		tables: map[string]bool{},
+	}
+}
+
+// get collectors in model stats
+func (stats *ModelStats) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{stats.Operations}
+}
+
+// RegisterModelMetrics counts the create, query, update and delete operations on the table of model, e.g.
+//
+//	db.Use(p)
+//	p.RegisterModelMetrics(&User{})
+//
+// Unlike TableLabels, it doesn't add a label to the query metrics, only the registered models are counted.
+// It must be called after the plugin is initialized, the table name is resolved with the naming strategy of the DB.
+func (p *Prometheus) RegisterModelMetrics(model interface{}) error {
+	if p.DB == nil {
+		return errors.New("gorm:prometheus RegisterModelMetrics requires the plugin to be initialized by db.Use")
+	}
+
+	stmt := &gorm.Statement{DB: p.DB}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+
+	// a failed registration is returned, and tried again by the next call
+	p.modelLock.Lock()
+	defer p.modelLock.Unlock()
+	if p.ModelStats == nil {
+		stats := newModelStats(p.Labels, p.Config)
+		registerer := p.registerer(nil)
+		if err := registerer.Register(stats.Operations); err != nil {
+			existing, ok := err.(prometheus.AlreadyRegisteredError)
+			if ok {
+				stats.Operations, ok = existing.ExistingCollector.(*prometheus.CounterVec)
+			}
+			if !ok {
+				registerer.Unregister(stats.Operations) // from the other Registerers
+				return fmt.Errorf("gorm:prometheus failed to register the model metrics: %v", err)
+			}
+		}
+
+		if err := stats.registerCallbacks(p.DB); err != nil {
+			registerer.Unregister(stats.Operations)
+			return err
+		}
+		p.ModelStats = stats
+	}
+
+	p.ModelStats.lock.Lock()
+	defer p.ModelStats.lock.Unlock()
+	p.ModelStats.tables[stmt.Table] = true
+	return nil
+}
+
+func (stats *ModelStats) registerCallbacks(db *gorm.DB) error {
+	for _, cp := range callbackProcessors(db) {
+		switch cp.operation {
+		case "create", "query", "update", "delete":
+			if err := cp.after("prometheus:model_"+cp.operation, stats.after(cp.operation)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (stats *ModelStats) after(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		stats.lock.RLock()
+		registered := stats.tables[db.Statement.Table]
+		stats.lock.RUnlock()
+
+		if registered {
+			stats.Operations.With(stats.config.transformLabels(map[string]string{"table": db.Statement.Table, "operation": operation})).Inc()
+		}
+	}
+}
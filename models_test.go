@@ -0,0 +1,83 @@
+package prometheus
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gorm.io/gorm"
+)
+
+type modelUser struct {
+	ID   uint
+	Name string
+}
+
+type modelOrder struct {
+	ID uint
+}
+
+func TestRegisterModelMetrics(t *testing.T) {
+	db, mock, p := newMockDB(t, Config{})
+	if err := p.RegisterModelMetrics(&modelUser{}); err != nil {
+		t.Fatalf("failed to register the model metrics, got error: %v", err)
+	}
+
+	mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	if err := db.Find(&[]modelUser{}).Error; err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if err := db.Find(&[]modelOrder{}).Error; err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+
+	if v := testutil.ToFloat64(p.ModelStats.Operations.WithLabelValues("model_users", "query")); v != 1 {
+		t.Errorf("expected 1 query on the registered model, got %v", v)
+	}
+	if n := testutil.CollectAndCount(p.ModelStats.Operations); n != 1 {
+		t.Errorf("expected only the registered model counted, got %d series", n)
+	}
+}
+
+// failingRegisterer fails the registrations while err is set
+type failingRegisterer struct {
+	prometheus.Registerer
+	err error
+}
+
+func (r *failingRegisterer) Register(c prometheus.Collector) error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.Registerer.Register(c)
+}
+
+func TestRegisterModelMetricsError(t *testing.T) {
+	db, mock := newMockGorm(t, &gorm.Config{})
+	p := usePlugin(t, db, Config{})
+
+	registerer := &failingRegisterer{Registerer: prometheus.NewRegistry(), err: errors.New("registry closed")}
+	p.Config.Registerers = []prometheus.Registerer{registerer}
+	if err := p.RegisterModelMetrics(&modelUser{}); err == nil || p.ModelStats != nil {
+		t.Fatalf("expected the registration error, got %v", err)
+	}
+
+	// the registration is tried again by the next call
+	registerer.err = nil
+	if err := p.RegisterModelMetrics(&modelUser{}); err != nil || p.ModelStats == nil {
+		t.Fatalf("failed to register the model metrics again, got error: %v", err)
+	}
+
+	mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	if err := db.Find(&[]modelUser{}).Error; err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	for _, gatherer := range []prometheus.Gatherer{p.Config.Registry, registerer.Registerer.(prometheus.Gatherer)} {
+		if n, err := testutil.GatherAndCount(gatherer, "gorm_model_operations_total"); err != nil || n != 1 {
+			t.Errorf("expected the query counted in every registerer, got %d %v", n, err)
+		}
+	}
+}
@@ -2,115 +2,454 @@ package prometheus
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"math"
+	"regexp"
 	"strconv"
-	"time"
-	"unicode"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// DefaultMySQLCounterNames are the status variables exposed as counters by default, aborted connects and
+// clients often indicate networking or credential problems and are worth alerting on
+var DefaultMySQLCounterNames = []string{"Aborted_connects", "Aborted_clients"}
+
 type MySQL struct {
 	Prefix        string
 	Interval      uint32
 	VariableNames []string
-	status        map[string]prometheus.Gauge
+	CounterNames  []string                                 // variables exposed as counters instead of gauges, default DefaultMySQLCounterNames
+	SkipZero      bool                                     // if true, variables are not exposed until they are non-zero, e.g. counters of unused features
+	Registerer    prometheus.Registerer                    // registers the metrics, default the plugin registerer
+	ParseFuncs    map[string]func(string) (float64, error) // parse the value of these variables, e.g. with a unit suffix
+	SessionSetup  []string                                 // statements run once on a connection dedicated to SHOW STATUS, e.g. SET SESSION ..., requires StatusDB or Hosts
+	// Hosts scrapes SHOW STATUS from each of these DBs instead of the plugin DB, labeling the metrics with the
+	// host key, e.g. the nodes of a cluster accessed through ProxySQL or Vitess, whose SHOW STATUS returns the
+	// proxy's own variables or aggregates. Each DB must connect to its node directly rather than through the proxy,
+	// a pool of one connection is enough, a node that can't be reached is logged and skipped until the next refresh
+	Hosts         map[string]*sql.DB
+	registerer    prometheus.Registerer
+	parseFailures prometheus.Counter
+	status        map[string]statusMetric
+	lock          sync.Mutex
+	conn          *sql.Conn // dedicated connection set up by SessionSetup
+	connLock      sync.Mutex
+	mariaDB       bool              // set by MariaDB once the server is detected, before the collector is refreshed
+	detectMariaDB bool              // set by MariaDB, the Hosts detect their own server
+	host          string            // Hosts key of a host collector
+	hosts         map[string]*MySQL // collectors of the Hosts, by key
+	metricsResult
 }
 
 func (m *MySQL) Metrics(p *Prometheus) []prometheus.Collector {
-	if m.Prefix == "" {
-		m.Prefix = "gorm_status_"
-	}
-
 	if m.Interval == 0 {
 		m.Interval = p.RefreshInterval
 	}
 
+	if m.CounterNames == nil {
+		m.CounterNames = DefaultMySQLCounterNames
+	}
+
 	if m.status == nil {
-		m.status = map[string]prometheus.Gauge{}
+		m.status = map[string]statusMetric{}
 	}
 
-	go func() {
-		for range time.Tick(time.Duration(m.Interval) * time.Second) {
-			m.collect(p)
-		}
-	}()
+	m.metricsErr = m.collect(p)
 
-	m.collect(p)
-	collectors := make([]prometheus.Collector, 0, len(m.status))
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	collectors := make([]prometheus.Collector, 0, len(m.status)+1)
 
 	for _, v := range m.status {
 		collectors = append(collectors, v)
 	}
 
+	if m.parseFailures != nil {
+		collectors = append(collectors, m.parseFailures)
+	}
+
+	for _, host := range m.hosts {
+		host.lock.Lock()
+		for _, v := range host.status {
+			collectors = append(collectors, v)
+		}
+		if host.parseFailures != nil {
+			collectors = append(collectors, host.parseFailures)
+		}
+		host.lock.Unlock()
+	}
+
 	return collectors
 }
 
-func (m *MySQL) collect(p *Prometheus) {
-	rows, err := p.DB.Raw("SHOW STATUS").Rows()
+// SetVariableNames replaces the watched status variables at runtime, gauges of the variables no longer
+// watched are unregistered, new ones are registered by the next collect
+func (m *MySQL) SetVariableNames(names []string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.VariableNames = append([]string(nil), names...)
+	if len(names) == 0 {
+		return
+	}
+
+	watched := make(map[string]bool, len(names))
+	for _, name := range names {
+		watched[name] = true
+	}
+
+	for name, metric := range m.status {
+		if !watched[name] {
+			m.registerer.Unregister(metric)
+			delete(m.status, name)
+		}
+	}
+
+	for _, host := range m.hosts {
+		host.SetVariableNames(names)
+	}
+}
+
+func (m *MySQL) RefreshInterval() uint32 {
+	return m.Interval
+}
+
+// Name is "mariadb" once MariaDB detected the server, "mysql" otherwise
+func (m *MySQL) Name() string {
+	if m.mariaDB {
+		return "mariadb"
+	}
+	return "mysql"
+}
+
+func (m *MySQL) Refresh(p *Prometheus) error {
+	return m.collect(p)
+}
+
+func (m *MySQL) collect(p *Prometheus) error {
+	if len(m.Hosts) > 0 {
+		return m.collectHosts(p)
+	}
+
+	// the dedicated connection is kept until Stop, it mustn't shrink the application pool
+	if len(m.SessionSetup) > 0 && p.Config.StatusDB == nil {
+		err := errors.New("gorm:prometheus MySQL SessionSetup requires StatusDB or Hosts, not to take a connection out of the application pool")
+		p.DB.Logger.Error(context.Background(), "%v", err)
+		return err
+	}
+
+	db, err := p.statusSQLDB()
+	if err != nil {
+		p.DB.Logger.Error(context.Background(), "gorm:prometheus failed to get db, got error: %v", err)
+		return err
+	}
+	return m.collectDB(p, db)
+}
+
+// collectHosts collects the status variables of every host, the first error is returned once all are collected
+func (m *MySQL) collectHosts(p *Prometheus) error {
+	m.lock.Lock()
+	if m.hosts == nil {
+		m.hosts = map[string]*MySQL{}
+	}
+
+	hosts := make([]*MySQL, 0, len(m.Hosts))
+	for key := range m.Hosts {
+		host, ok := m.hosts[key]
+		if !ok {
+			host = &MySQL{
+				Prefix:        m.Prefix,
+				VariableNames: m.VariableNames,
+				CounterNames:  m.CounterNames,
+				SkipZero:      m.SkipZero,
+				Registerer:    m.Registerer,
+				ParseFuncs:    m.ParseFuncs,
+				SessionSetup:  m.SessionSetup,
+				status:        map[string]statusMetric{},
+				host:          key,
+			}
+			if m.detectMariaDB { // once, the nodes of a cluster may run different servers
+				host.mariaDB = isMariaDB(p, m.Hosts[key])
+			}
+			m.hosts[key] = host
+		}
+		hosts = append(hosts, host)
+	}
+	m.lock.Unlock()
+
+	var firstErr error
+	for _, host := range hosts {
+		if err := host.collectDB(p, m.Hosts[host.host]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// collectDB collects the status variables from db, on the connection set up by SessionSetup if any
+func (m *MySQL) collectDB(p *Prometheus, db *sql.DB) (err error) {
+	if len(m.SessionSetup) == 0 {
+		return m.collectFrom(p, db)
+	}
+
+	m.connLock.Lock()
+	defer m.connLock.Unlock()
+
+	if m.conn == nil {
+		if m.conn, err = m.setupConn(db); err != nil {
+			p.DB.Logger.Error(context.Background(), "gorm:prometheus session setup got error: %v", err)
+			return err
+		}
+	}
+
+	if err = m.collectFrom(p, m.conn); err != nil {
+		// the session state is unknown after an error, set up a new connection next time
+		discardConn(m.conn)
+		m.conn = nil
+	}
+	return err
+}
+
+// setupConn takes a connection out of db and runs the SessionSetup statements on it, the connection isn't
+// returned to db so the session state doesn't leak to the application
+func (m *MySQL) setupConn(db *sql.DB) (*sql.Conn, error) {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stmt := range m.SessionSetup {
+		if _, err = conn.ExecContext(context.Background(), stmt); err != nil {
+			discardConn(conn)
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// Close releases the connections set up by SessionSetup, it's called by Stop
+func (m *MySQL) Close() error {
+	m.connLock.Lock()
+	if m.conn != nil {
+		discardConn(m.conn)
+		m.conn = nil
+	}
+	m.connLock.Unlock()
+
+	m.lock.Lock()
+	hosts := make([]*MySQL, 0, len(m.hosts))
+	for _, host := range m.hosts {
+		hosts = append(hosts, host)
+	}
+	m.lock.Unlock()
+
+	for _, host := range hosts {
+		_ = host.Close()
+	}
+	return nil
+}
+
+// discardConn closes the connection instead of returning it to the pool
+func discardConn(conn *sql.Conn) {
+	_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+}
+
+// queryer is a *sql.DB or a *sql.Conn
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// collectFrom reads the status variables from db
+func (m *MySQL) collectFrom(p *Prometheus, db queryer) error {
+	m.lock.Lock()
+	query := statusQuery(m.VariableNames)
+	m.lock.Unlock()
+
+	rows, err := db.QueryContext(context.Background(), query)
 
 	if err != nil {
 		p.DB.Logger.Error(context.Background(), "gorm:prometheus query error: %v", err)
-		return
+		return err
+	}
+	defer rows.Close()
+
+	// hold the lock while scanning, so a concurrent SetVariableNames is applied to a whole refresh
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.registerer = p.registerer(m.Registerer) // kept for SetVariableNames
+
+	if m.parseFailures == nil {
+		m.parseFailures = prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        p.statusName(m.Prefix, "parse_failures_total"),
+			Help:        p.help(p.statusName(m.Prefix, "parse_failures_total"), "The total number of numeric status values that failed to parse."),
+			ConstLabels: m.labels(p),
+		})
+		_ = m.registerer.Register(m.parseFailures)
 	}
 
-	var variableName, variableValue string
-	for rows.Next() {
+	wanted := make(map[string]bool, len(m.VariableNames))
+	for _, name := range m.VariableNames {
+		wanted[name] = true
+	}
+
+	var (
+		variableName, variableValue string
+		read                        int // wanted variables read
+	)
+	for (len(wanted) == 0 || read < len(wanted)) && rows.Next() { // stop once all are read
 		err = rows.Scan(&variableName, &variableValue)
 		if err != nil {
 			p.DB.Logger.Error(context.Background(), "gorm:prometheus scan got error: %v", err)
 			continue
 		}
 
-		var found = len(m.VariableNames) == 0
-
-		for _, name := range m.VariableNames {
-			if name == variableName {
-				found = true
-				break
-			}
-		}
-
-		if found {
-			// check if variableValue is string
-			if variableValue == "" {
+		if len(wanted) == 0 || wanted[variableName] {
+			read++
+			value, ok := m.parse(p, variableName, variableValue)
+			if !ok {
 				continue
 			}
 
-			isFloat64 := true
-			for _, r := range variableValue {
-				if !unicode.IsNumber(r) {
-					isFloat64 = false
-					break
-				}
-				if r == ':' {
-					isFloat64 = false
-					break
+			metric, ok := m.status[variableName]
+			if !ok {
+				if m.SkipZero && value == 0 {
+					continue
 				}
-			}
-			if !isFloat64 {
-				continue
-			}
 
-			value, err := strconv.ParseFloat(variableValue, 64)
-			if err != nil {
-				p.DB.Logger.Error(context.Background(), "gorm:prometheus parse float got error: %v", err)
-				continue
+				metric = m.newStatusMetric(p, variableName)
+				m.status[variableName] = metric
+				_ = m.registerer.Register(metric)
 			}
 
-			gauge, ok := m.status[variableName]
-			if !ok {
-				gauge = prometheus.NewGauge(prometheus.GaugeOpts{
-					Name:        m.Prefix + variableName,
-					ConstLabels: p.Labels,
-				})
+			metric.Set(value)
+		}
+	}
 
-				m.status[variableName] = gauge
-				_ = prometheus.Register(gauge)
-			}
+	if err = rows.Err(); err != nil {
+		p.DB.Logger.Error(context.Background(), "gorm:prometheus rows got error: %v", err)
+	}
+	return err
+}
+
+// quoteReplacer escapes a string literal of MySQL
+var quoteReplacer = strings.NewReplacer(`\`, `\\`, "'", "''")
+
+// statusQuery returns the query of the status variables, filtered by name on the server if names are given
+func statusQuery(names []string) string {
+	if len(names) == 0 {
+		return "SHOW STATUS"
+	}
+
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = "'" + quoteReplacer.Replace(name) + "'"
+	}
+	return "SHOW STATUS WHERE Variable_name IN (" + strings.Join(quoted, ", ") + ")"
+}
+
+// newStatusMetric creates the counter or gauge exposing the status variable
+func (m *MySQL) newStatusMetric(p *Prometheus, variableName string) statusMetric {
+	name := p.statusName(m.Prefix, variableName)
+	help := p.help(name, "Value of the MySQL status variable "+variableName+".")
+
+	for _, counterName := range m.CounterNames {
+		if counterName == variableName {
+			return &statusCounter{desc: prometheus.NewDesc(name, help, nil, m.labels(p))}
+		}
+	}
+
+	return prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        name,
+		Help:        help,
+		ConstLabels: m.labels(p),
+	})
+}
+
+// labels returns the const labels of the metrics, with the host of a host collector
+func (m *MySQL) labels(p *Prometheus) map[string]string {
+	if m.host == "" {
+		return p.collectorLabels(m)
+	}
+
+	labels := map[string]string{}
+	for k, v := range p.collectorLabels(m) {
+		labels[k] = v
+	}
+	for k, v := range p.transformLabels(map[string]string{"host": m.host}) {
+		labels[k] = v
+	}
+	return labels
+}
+
+// numericValue matches values formatted as numbers, with thousands separators, a decimal part, an exponent or
+// a unit suffix, other values, e.g. ON, dates or versions, aren't numbers and are skipped without failure
+var numericValue = regexp.MustCompile(`^[+-]?(\d{1,3}(,\d{3})+|\d*)(\.\d+)?([eE][+-]?\d+)?(\s*[A-Za-z%]+)?$`)
+
+// parse returns the numeric value of the status variable, false if it isn't numeric
+func (m *MySQL) parse(p *Prometheus, variableName, variableValue string) (float64, bool) {
+	if parse, ok := m.ParseFuncs[variableName]; ok {
+		value, err := parse(variableValue)
+		if err != nil {
+			m.parseFailed(p, variableName, err)
+			return 0, false
+		}
+		return value, true
+	}
 
-			gauge.Set(value)
+	if m.mariaDB {
+		if value, ok := mariaDBValue(variableValue); ok {
+			return value, true
 		}
 	}
 
-	return
+	variableValue = strings.TrimSpace(variableValue)
+	if !strings.ContainsAny(variableValue, "0123456789") || !numericValue.MatchString(variableValue) {
+		return 0, false
+	}
+
+	value, err := strconv.ParseFloat(strings.Replace(variableValue, ",", "", -1), 64)
+	if err != nil { // e.g. a unit suffix, parsed by ParseFuncs
+		m.parseFailed(p, variableName, err)
+		return 0, false
+	}
+	return value, true
+}
+
+func (m *MySQL) parseFailed(p *Prometheus, variableName string, err error) {
+	p.DB.Logger.Error(context.Background(), "gorm:prometheus parse %s got error: %v", variableName, err)
+	m.parseFailures.Inc()
+}
+
+// statusMetric is a gauge or a counter exposing a status variable
+type statusMetric interface {
+	prometheus.Collector
+	Set(float64)
+}
+
+// statusCounter exposes a status variable counting since the server started, the server keeps the
+// count so the value is set as read rather than incremented
+type statusCounter struct {
+	bits uint64 // float64 bits of the value, accessed atomically, first to be 64-bit aligned
+	desc *prometheus.Desc
+}
+
+func (c *statusCounter) Set(value float64) {
+	atomic.StoreUint64(&c.bits, math.Float64bits(value))
+}
+
+func (c *statusCounter) value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&c.bits))
+}
+
+func (c *statusCounter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *statusCounter) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, c.value())
 }
@@ -0,0 +1,117 @@
+package prometheus
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultMySQLDigestLimit = 10
+
+// MySQLDigest exposes the average latency of the slowest statement digests from the MySQL performance_schema,
+// nothing is emitted while performance_schema is disabled
+type MySQLDigest struct {
+	Prefix     string
+	Interval   uint32
+	Limit      int                   // number of digests exposed, ordered by average latency, default 10
+	Registerer prometheus.Registerer // registers the metrics, default the plugin registerer
+	latency    *prometheus.GaugeVec
+	calls      *prometheus.GaugeVec
+	once       sync.Once
+	metricsResult
+}
+
+func (m *MySQLDigest) Metrics(p *Prometheus) []prometheus.Collector {
+	if m.Interval == 0 {
+		m.Interval = p.RefreshInterval
+	}
+
+	if m.Limit == 0 {
+		m.Limit = defaultMySQLDigestLimit
+	}
+
+	m.once.Do(func() {
+		labelNames := p.transformLabelNames([]string{"schema", "digest", "statement"})
+		m.latency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        p.statusName(m.Prefix, "statement_digest_avg_latency_seconds"),
+			Help:        p.help(p.statusName(m.Prefix, "statement_digest_avg_latency_seconds"), "Average latency of the slowest statement digests, in seconds."),
+			ConstLabels: p.collectorLabels(m),
+		}, labelNames)
+		m.calls = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        p.statusName(m.Prefix, "statement_digest_calls"),
+			Help:        p.help(p.statusName(m.Prefix, "statement_digest_calls"), "Number of executions of the slowest statement digests since the summary was reset."),
+			ConstLabels: p.collectorLabels(m),
+		}, labelNames)
+		_ = p.registerer(m.Registerer).Register(m.latency)
+		_ = p.registerer(m.Registerer).Register(m.calls)
+	})
+
+	m.metricsErr = m.collect(p)
+
+	return []prometheus.Collector{m.latency, m.calls}
+}
+
+func (m *MySQLDigest) RefreshInterval() uint32 {
+	return m.Interval
+}
+
+func (m *MySQLDigest) Name() string {
+	return "mysql_digest"
+}
+
+func (m *MySQLDigest) Refresh(p *Prometheus) error {
+	return m.collect(p)
+}
+
+func (m *MySQLDigest) collect(p *Prometheus) error {
+	db, err := p.statusSQLDB()
+	if err != nil {
+		p.DB.Logger.Error(context.Background(), "gorm:prometheus failed to get db, got error: %v", err)
+		return err
+	}
+
+	var enabled bool
+	if err = db.QueryRow("SELECT @@performance_schema").Scan(&enabled); err != nil {
+		p.DB.Logger.Error(context.Background(), "gorm:prometheus query error: %v", err)
+		return err
+	}
+
+	if !enabled {
+		m.latency.Reset()
+		m.calls.Reset()
+		return nil
+	}
+
+	rows, err := db.Query("SELECT IFNULL(SCHEMA_NAME, ''), DIGEST, IFNULL(LEFT(DIGEST_TEXT, 100), ''), COUNT_STAR, AVG_TIMER_WAIT "+
+		"FROM performance_schema.events_statements_summary_by_digest WHERE DIGEST IS NOT NULL ORDER BY AVG_TIMER_WAIT DESC LIMIT ?", m.Limit)
+	if err != nil {
+		p.DB.Logger.Error(context.Background(), "gorm:prometheus query error: %v", err)
+		return err
+	}
+	defer rows.Close()
+
+	// the slowest digests change between refreshes, only the current ones are exposed
+	m.latency.Reset()
+	m.calls.Reset()
+
+	var (
+		schema, digest, statement string
+		calls, avgTimerWait       float64
+	)
+	for rows.Next() {
+		if err = rows.Scan(&schema, &digest, &statement, &calls, &avgTimerWait); err != nil {
+			p.DB.Logger.Error(context.Background(), "gorm:prometheus scan got error: %v", err)
+			continue
+		}
+
+		labels := prometheus.Labels(p.transformLabels(map[string]string{"schema": schema, "digest": digest, "statement": statement}))
+		m.latency.With(labels).Set(avgTimerWait / 1e12) // timers are in picoseconds
+		m.calls.With(labels).Set(calls)
+	}
+
+	if err = rows.Err(); err != nil {
+		p.DB.Logger.Error(context.Background(), "gorm:prometheus rows got error: %v", err)
+	}
+	return err
+}
@@ -0,0 +1,38 @@
+package prometheus
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMySQLDigest(t *testing.T) {
+	p, mock := newMockPrometheus(t)
+	mock.ExpectQuery("SELECT @@performance_schema").WillReturnRows(sqlmock.NewRows([]string{"@@performance_schema"}).AddRow(1))
+	mock.ExpectQuery("events_statements_summary_by_digest").WithArgs(2).WillReturnRows(
+		sqlmock.NewRows([]string{"SCHEMA_NAME", "DIGEST", "DIGEST_TEXT", "COUNT_STAR", "AVG_TIMER_WAIT"}).
+			AddRow("shop", "abc", "SELECT * FROM `orders` WHERE `id` = ?", 42, 1.5e12).
+			AddRow("shop", "def", "UPDATE `users` SET `name` = ?", 7, 2e11),
+	)
+	mock.ExpectQuery("SELECT @@performance_schema").WillReturnRows(sqlmock.NewRows([]string{"@@performance_schema"}).AddRow(0))
+
+	m := &MySQLDigest{Prefix: "test_digest_", Limit: 2}
+	m.Metrics(p)
+
+	if v := testutil.ToFloat64(m.latency.WithLabelValues("shop", "abc", "SELECT * FROM `orders` WHERE `id` = ?")); v != 1.5 {
+		t.Errorf("expected average latency 1.5, got %v", v)
+	}
+
+	if err := m.Refresh(p); err != nil {
+		t.Fatalf("failed to refresh, got error: %v", err)
+	}
+
+	if n := testutil.CollectAndCount(m.latency); n != 0 {
+		t.Errorf("performance_schema is disabled, expected no digests, got %d", n)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
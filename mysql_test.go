@@ -1 +1,401 @@
 package prometheus
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func statusRows(variables ...string) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"Variable_name", "Value"})
+	for i := 0; i+1 < len(variables); i += 2 {
+		rows.AddRow(variables[i], variables[i+1])
+	}
+	return rows
+}
+
+func TestMySQLCollect(t *testing.T) {
+	p, mock := newMockPrometheus(t)
+	mock.ExpectQuery("SHOW STATUS").WillReturnRows(statusRows(
+		"Threads_running", "3",
+		"Uptime", "1024",
+		"Ssl_cipher", "",
+		"Rsa_public_key", "-----BEGIN PUBLIC KEY-----",
+		"Innodb_buffer_pool_dump_status", "Dumping of buffer pool not started",
+	)).RowsWillBeClosed()
+
+	m := &MySQL{Prefix: "test_collect_", VariableNames: []string{"Threads_running", "Ssl_cipher", "Rsa_public_key"}}
+	collectors := m.Metrics(p)
+
+	if len(collectors) != 2 { // Threads_running and parse failures
+		t.Fatalf("expected 2 collectors, got %d", len(collectors))
+	}
+
+	if v := testutil.ToFloat64(m.status["Threads_running"]); v != 3 {
+		t.Errorf("expected Threads_running 3, got %v", v)
+	}
+
+	if _, ok := m.status["Uptime"]; ok {
+		t.Errorf("Uptime is not watched, should not be collected")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestMySQLCollectorLabel(t *testing.T) {
+	p, mock := newMockPrometheus(t)
+	p.Config.CollectorLabel = true
+	mock.ExpectQuery("SHOW STATUS").WillReturnRows(statusRows("Threads_running", "3"))
+
+	m := &MySQL{Prefix: "test_collector_label_", VariableNames: []string{"Threads_running"}}
+	m.Metrics(p)
+
+	expected := `
+# HELP test_collector_label_Threads_running Value of the MySQL status variable Threads_running.
+# TYPE test_collector_label_Threads_running gauge
+test_collector_label_Threads_running{collector="mysql"} 3
+`
+	if err := testutil.CollectAndCompare(m.status["Threads_running"], strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMySQLHosts(t *testing.T) {
+	p, _ := newMockPrometheus(t)
+	hosts := map[string]*sql.DB{}
+	for i, running := range []string{"3", "5"} {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("failed to create sqlmock, got error: %v", err)
+		}
+		t.Cleanup(func() { _ = db.Close() })
+		mock.ExpectQuery("SHOW STATUS").WillReturnRows(statusRows("Threads_running", running))
+		hosts["node"+strconv.Itoa(i+1)] = db
+	}
+
+	registry := prometheus.NewRegistry()
+	m := &MySQL{Prefix: "test_hosts_", VariableNames: []string{"Threads_running"}, Hosts: hosts, Registerer: registry}
+	if collectors := m.Metrics(p); len(collectors) != 4 { // Threads_running and parse failures of each host
+		t.Fatalf("expected 4 collectors, got %d", len(collectors))
+	}
+
+	expected := `
+# HELP test_hosts_Threads_running Value of the MySQL status variable Threads_running.
+# TYPE test_hosts_Threads_running gauge
+test_hosts_Threads_running{host="node1"} 3
+test_hosts_Threads_running{host="node2"} 5
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "test_hosts_Threads_running"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMySQLCollectAllVariables(t *testing.T) {
+	p, mock := newMockPrometheus(t)
+	mock.ExpectQuery("SHOW STATUS").WillReturnRows(statusRows(
+		"Threads_running", "3",
+		"Uptime", "1024",
+		"Ssl_version", "TLSv1.3",
+	))
+
+	m := &MySQL{Prefix: "test_collect_all_"}
+	if collectors := m.Metrics(p); len(collectors) != 3 {
+		t.Fatalf("expected 3 collectors, got %d", len(collectors))
+	}
+
+	if v := testutil.ToFloat64(m.status["Uptime"]); v != 1024 {
+		t.Errorf("expected Uptime 1024, got %v", v)
+	}
+}
+
+func TestMySQLCollectQueryError(t *testing.T) {
+	p, mock := newMockPrometheus(t)
+	queryErr := errors.New("access denied")
+	mock.ExpectQuery("SHOW STATUS").WillReturnError(queryErr)
+
+	m := &MySQL{Prefix: "test_query_error_", status: map[string]statusMetric{}}
+	if err := m.Refresh(p); !errors.Is(err, queryErr) {
+		t.Errorf("expected query error, got %v", err)
+	}
+}
+
+func TestMySQLCollectRowError(t *testing.T) {
+	p, mock := newMockPrometheus(t)
+	rowErr := errors.New("connection reset")
+	mock.ExpectQuery("SHOW STATUS").WillReturnRows(statusRows(
+		"Threads_running", "3",
+		"Uptime", "1024",
+	).RowError(1, rowErr)).RowsWillBeClosed()
+
+	m := &MySQL{Prefix: "test_row_error_", status: map[string]statusMetric{}}
+	if err := m.Refresh(p); !errors.Is(err, rowErr) {
+		t.Errorf("expected row error, got %v", err)
+	}
+
+	if v := testutil.ToFloat64(m.status["Threads_running"]); v != 3 {
+		t.Errorf("expected rows before the error to be collected, got %v", v)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestMySQLSessionSetup(t *testing.T) {
+	p, mock := newMockPrometheus(t)
+	p.Config.StatusDB = p.DB.ConnPool.(*sql.DB)
+	mock.ExpectExec("SET SESSION sql_mode").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SHOW STATUS").WillReturnRows(statusRows("Threads_running", "3"))
+	mock.ExpectQuery("SHOW STATUS").WillReturnRows(statusRows("Threads_running", "4"))
+	mock.ExpectQuery("SHOW STATUS").WillReturnError(errors.New("connection lost"))
+
+	m := &MySQL{Prefix: "test_session_setup_", VariableNames: []string{"Threads_running"}, SessionSetup: []string{"SET SESSION sql_mode = ''"}}
+	m.Metrics(p)
+	if err := m.Refresh(p); err != nil {
+		t.Fatalf("failed to refresh, got error: %v", err)
+	}
+
+	if v := testutil.ToFloat64(m.status["Threads_running"]); v != 4 {
+		t.Errorf("expected Threads_running 4, got %v", v)
+	}
+
+	// the connection is discarded after an error, to be set up again
+	if err := m.Refresh(p); err == nil || m.conn != nil {
+		t.Errorf("expected the connection to be discarded after error %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestMySQLSessionSetupStatusDB(t *testing.T) {
+	p, mock := newMockPrometheus(t)
+
+	// without StatusDB, no connection is taken out of the application pool
+	m := &MySQL{Prefix: "test_session_setup_status_db_", VariableNames: []string{"Threads_running"}, SessionSetup: []string{"SET SESSION sql_mode = ''"}}
+	m.Metrics(p)
+	if err := m.Refresh(p); err == nil || m.conn != nil {
+		t.Errorf("expected an error without StatusDB, got %v", err)
+	}
+
+	p.Config.StatusDB = p.DB.ConnPool.(*sql.DB)
+	p.MetricsCollector = []MetricsCollector{m}
+	mock.ExpectExec("SET SESSION sql_mode").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SHOW STATUS").WillReturnRows(statusRows("Threads_running", "3"))
+	if err := m.Refresh(p); err != nil || m.conn == nil {
+		t.Fatalf("expected the connection to be set up, got error: %v", err)
+	}
+
+	// Stop releases the connection
+	p.Stop()
+	if m.conn != nil {
+		t.Errorf("expected the connection to be released by Stop")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestMySQLSetVariableNames(t *testing.T) {
+	p, mock := newMockPrometheus(t)
+	mock.ExpectQuery("SHOW STATUS").WillReturnRows(statusRows("Threads_running", "3", "Uptime", "1024"))
+	mock.ExpectQuery("SHOW STATUS").WillReturnRows(statusRows("Threads_running", "4", "Uptime", "1025"))
+
+	m := &MySQL{Prefix: "test_set_variable_names_", VariableNames: []string{"Threads_running"}}
+	m.Metrics(p)
+
+	m.SetVariableNames([]string{"Uptime"})
+	if _, ok := m.status["Threads_running"]; ok {
+		t.Errorf("Threads_running is no longer watched, should be removed")
+	}
+
+	if err := m.Refresh(p); err != nil {
+		t.Fatalf("failed to refresh, got error: %v", err)
+	}
+
+	if v := testutil.ToFloat64(m.status["Uptime"]); v != 1025 {
+		t.Errorf("expected Uptime 1025, got %v", v)
+	}
+}
+
+func TestMySQLSkipZero(t *testing.T) {
+	p, mock := newMockPrometheus(t)
+	mock.ExpectQuery("SHOW STATUS").WillReturnRows(statusRows("Threads_running", "3", "Com_xa_start", "0"))
+	mock.ExpectQuery("SHOW STATUS").WillReturnRows(statusRows("Threads_running", "0", "Com_xa_start", "1"))
+
+	m := &MySQL{Prefix: "test_skip_zero_", SkipZero: true}
+	m.Metrics(p)
+
+	if _, ok := m.status["Com_xa_start"]; ok {
+		t.Errorf("Com_xa_start has only been 0, should not be exposed")
+	}
+
+	if err := m.Refresh(p); err != nil {
+		t.Fatalf("failed to refresh, got error: %v", err)
+	}
+
+	if v := testutil.ToFloat64(m.status["Threads_running"]); v != 0 {
+		t.Errorf("expected Threads_running to be set back to 0, got %v", v)
+	}
+
+	if v := testutil.ToFloat64(m.status["Com_xa_start"]); v != 1 {
+		t.Errorf("expected Com_xa_start 1, got %v", v)
+	}
+}
+
+func TestMySQLParse(t *testing.T) {
+	p, mock := newMockPrometheus(t)
+	mock.ExpectQuery("SHOW STATUS").WillReturnRows(statusRows(
+		"Threads_running", " 42 ",
+		"Bytes_received", "1,234,567",
+		"Innodb_data_fsync_ratio", "1.5e3",
+		"Buffer_size", "12K",
+		"Memory_used", "16M",
+		"Ssl_server_not_after", "2030-01-01 00:00:00",
+	))
+
+	m := &MySQL{Prefix: "test_parse_", ParseFuncs: map[string]func(string) (float64, error){
+		"Memory_used": func(value string) (float64, error) {
+			return strconv.ParseFloat(strings.TrimSuffix(value, "M"), 64)
+		},
+	}}
+	m.Metrics(p)
+
+	for name, expected := range map[string]float64{"Threads_running": 42, "Bytes_received": 1234567, "Innodb_data_fsync_ratio": 1500, "Memory_used": 16} {
+		if v := testutil.ToFloat64(m.status[name]); v != expected {
+			t.Errorf("expected %s %v, got %v", name, expected, v)
+		}
+	}
+
+	if v := testutil.ToFloat64(m.parseFailures); v != 1 {
+		t.Errorf("expected 1 parse failure for Buffer_size, got %v", v)
+	}
+}
+
+func TestMySQLCounterNames(t *testing.T) {
+	p, mock := newMockPrometheus(t)
+	mock.ExpectQuery("SHOW STATUS").WillReturnRows(statusRows(
+		"Threads_running", "3",
+		"Aborted_connects", "12",
+	))
+
+	m := &MySQL{Prefix: "test_counter_names_"}
+	m.Metrics(p)
+
+	expected := `
+# HELP test_counter_names_Aborted_connects Value of the MySQL status variable Aborted_connects.
+# TYPE test_counter_names_Aborted_connects counter
+test_counter_names_Aborted_connects 12
+`
+	if err := testutil.CollectAndCompare(m.status["Aborted_connects"], strings.NewReader(expected)); err != nil {
+		t.Errorf("expected Aborted_connects to be a counter: %v", err)
+	}
+
+	if v := testutil.ToFloat64(m.status["Threads_running"]); v != 3 {
+		t.Errorf("expected Threads_running 3, got %v", v)
+	}
+}
+
+func TestMySQLStatusQuery(t *testing.T) {
+	if query := statusQuery(nil); query != "SHOW STATUS" {
+		t.Errorf("expected SHOW STATUS, got %s", query)
+	}
+
+	expected := `SHOW STATUS WHERE Variable_name IN ('Threads_running', 'it''s\\')`
+	if query := statusQuery([]string{"Threads_running", `it's\`}); query != expected {
+		t.Errorf("expected %s, got %s", expected, query)
+	}
+}
+
+// BenchmarkMySQLCollect compares reading the wanted variables at the end of a large result, as a full scan,
+// with reading them at the start, where the scan stops early
+func BenchmarkMySQLCollect(b *testing.B) {
+	variables := make([]string, 0, 4000)
+	for i := 0; i < 2000; i++ {
+		variables = append(variables, "Variable_"+strconv.Itoa(i), strconv.Itoa(i))
+	}
+	wanted := []string{"Threads_running", "3", "Threads_connected", "10"}
+
+	for name, rows := range map[string][]string{
+		"full_scan":  append(append([]string(nil), variables...), wanted...),
+		"early_exit": append(append([]string(nil), wanted...), variables...),
+	} {
+		b.Run(name, func(b *testing.B) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				b.Fatalf("failed to create sqlmock, got error: %v", err)
+			}
+			defer db.Close()
+
+			p := New(Config{})
+			p.DB = &gorm.DB{Config: &gorm.Config{Logger: logger.Discard, ConnPool: db}}
+			m := &MySQL{Prefix: "bench_" + name + "_", VariableNames: []string{"Threads_running", "Threads_connected"}, status: map[string]statusMetric{}}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				mock.ExpectQuery("SHOW STATUS").WillReturnRows(statusRows(rows...))
+				b.StartTimer()
+
+				if err := m.collectFrom(p, db); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkMySQLCollectWanted collects 20 wanted variables out of a realistic status of 400 variables: the full
+// scan of the 400 rows of an unfiltered SHOW STATUS, as returned by servers or proxies not filtering SHOW STATUS
+// ... WHERE, against the 20 rows of the IN (...) filter
+func BenchmarkMySQLCollectWanted(b *testing.B) {
+	var all, filtered, wanted []string
+	for i := 0; i < 400; i++ {
+		name := "Variable_" + strconv.Itoa(i)
+		all = append(all, name, strconv.Itoa(i))
+		if i%20 == 19 {
+			filtered = append(filtered, name, strconv.Itoa(i))
+			wanted = append(wanted, name)
+		}
+	}
+
+	for name, rows := range map[string][]string{
+		"full_scan": all,
+		"in_filter": filtered,
+	} {
+		b.Run(name, func(b *testing.B) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				b.Fatalf("failed to create sqlmock, got error: %v", err)
+			}
+			defer db.Close()
+
+			p := New(Config{})
+			p.DB = &gorm.DB{Config: &gorm.Config{Logger: logger.Discard, ConnPool: db}}
+			m := &MySQL{Prefix: "bench_wanted_" + name + "_", VariableNames: wanted, status: map[string]statusMetric{}}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				mock.ExpectQuery("SHOW STATUS").WillReturnRows(statusRows(rows...))
+				b.StartTimer()
+
+				if err := m.collectFrom(p, db); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
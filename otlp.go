@@ -0,0 +1,230 @@
+package prometheus
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const defaultOTLPTimeout = 10 * time.Second
+
+// OTLPExporter is an Exporter sending the metrics to an OpenTelemetry collector with the OTLP/HTTP protocol, JSON
+// encoded, every ExportInterval, without depending on the OpenTelemetry SDK:
+//
+//	prometheus.New(prometheus.Config{
+//		DBName:   "db1",
+//		Exporter: &prometheus.OTLPExporter{Endpoint: "http://otel-collector:4318/v1/metrics"},
+//	})
+//
+// Counters are exported as cumulative monotonic sums, gauges and untyped metrics as gauges, histograms with their
+// buckets and summaries with their quantiles. For OTLP over gRPC, implement Exporter with the OpenTelemetry
+// Prometheus bridge and otlpmetricgrpc exporter instead
+type OTLPExporter struct {
+	Endpoint string            // URL of the OTLP/HTTP metrics receiver, e.g. "http://otel-collector:4318/v1/metrics"
+	Headers  http.Header       // added to the requests, e.g. the API key of a hosted collector
+	Resource map[string]string // attributes of the resource, e.g. {"service.name": "orders"}
+	Timeout  time.Duration     // timeout of an export, default 10 seconds
+	Client   *http.Client      // client sending the requests, e.g. with a TLS config, default http.DefaultClient
+
+	startOnce sync.Once
+	startedAt time.Time // start of the cumulative metrics, the first export
+}
+
+func (e *OTLPExporter) Export(ctx context.Context, gatherer prometheus.Gatherer) error {
+	e.startOnce.Do(func() {
+		e.startedAt = time.Now()
+	})
+
+	families, err := gatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(otlpRequest(families, e.Resource, e.startedAt, time.Now()))
+	if err != nil {
+		return err
+	}
+
+	timeout := e.Timeout
+	if timeout == 0 {
+		timeout = defaultOTLPTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range e.Headers {
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("OTLP export to %s failed with HTTP status %d: %s", e.Endpoint, resp.StatusCode, bytes.TrimSpace(message))
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body) // so the connection is reused
+	return nil
+}
+
+// otlpRequest converts the metric families to an OTLP ExportMetricsServiceRequest, in the JSON mapping of its
+// protobuf definition
+func otlpRequest(families []*dto.MetricFamily, resource map[string]string, startedAt, now time.Time) map[string]interface{} {
+	metrics := make([]map[string]interface{}, 0, len(families))
+	for _, family := range families {
+		if metric := otlpMetric(family, startedAt, now); metric != nil {
+			metrics = append(metrics, metric)
+		}
+	}
+
+	return map[string]interface{}{
+		"resourceMetrics": []map[string]interface{}{{
+			"resource": map[string]interface{}{"attributes": otlpAttributes(resource)},
+			"scopeMetrics": []map[string]interface{}{{
+				"scope":   map[string]interface{}{"name": "gorm.io/plugin/prometheus"},
+				"metrics": metrics,
+			}},
+		}},
+	}
+}
+
+// otlpCumulative is the AggregationTemporality of the counters and histograms, which are never reset
+const otlpCumulative = 2
+
+func otlpMetric(family *dto.MetricFamily, startedAt, now time.Time) map[string]interface{} {
+	points := make([]map[string]interface{}, 0, len(family.Metric))
+	for _, m := range family.Metric {
+		labels := make(map[string]string, len(m.Label))
+		for _, label := range m.Label {
+			labels[label.GetName()] = label.GetValue()
+		}
+
+		at := now
+		if m.TimestampMs != nil {
+			at = time.Unix(0, m.GetTimestampMs()*int64(time.Millisecond))
+		}
+
+		point := map[string]interface{}{
+			"attributes":        otlpAttributes(labels),
+			"startTimeUnixNano": otlpTime(startedAt),
+			"timeUnixNano":      otlpTime(at),
+		}
+
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			point["asDouble"] = otlpDouble(m.GetCounter().GetValue())
+		case dto.MetricType_GAUGE:
+			point["asDouble"] = otlpDouble(m.GetGauge().GetValue())
+		case dto.MetricType_UNTYPED:
+			point["asDouble"] = otlpDouble(m.GetUntyped().GetValue())
+		case dto.MetricType_HISTOGRAM:
+			otlpHistogram(point, m.GetHistogram())
+		case dto.MetricType_SUMMARY:
+			summary := m.GetSummary()
+			quantiles := make([]map[string]interface{}, 0, len(summary.Quantile))
+			for _, q := range summary.Quantile {
+				quantiles = append(quantiles, map[string]interface{}{"quantile": otlpDouble(q.GetQuantile()), "value": otlpDouble(q.GetValue())})
+			}
+			point["count"] = strconv.FormatUint(summary.GetSampleCount(), 10)
+			point["sum"] = otlpDouble(summary.GetSampleSum())
+			point["quantileValues"] = quantiles
+		default:
+			return nil // e.g. native histograms, not exposed by the plugin
+		}
+		points = append(points, point)
+	}
+
+	metric := map[string]interface{}{"name": family.GetName(), "description": family.GetHelp()}
+	switch family.GetType() {
+	case dto.MetricType_COUNTER:
+		metric["sum"] = map[string]interface{}{"dataPoints": points, "aggregationTemporality": otlpCumulative, "isMonotonic": true}
+	case dto.MetricType_HISTOGRAM:
+		metric["histogram"] = map[string]interface{}{"dataPoints": points, "aggregationTemporality": otlpCumulative}
+	case dto.MetricType_SUMMARY:
+		metric["summary"] = map[string]interface{}{"dataPoints": points}
+	default:
+		metric["gauge"] = map[string]interface{}{"dataPoints": points}
+	}
+	return metric
+}
+
+// otlpHistogram sets the buckets of the histogram, OTLP counts the observations of each bucket where Prometheus
+// buckets are cumulative, the last OTLP bucket is the +Inf one
+func otlpHistogram(point map[string]interface{}, h *dto.Histogram) {
+	var (
+		bounds = make([]interface{}, 0, len(h.Bucket))
+		counts = make([]string, 0, len(h.Bucket)+1)
+		below  uint64
+	)
+	for _, bucket := range h.Bucket {
+		if math.IsInf(bucket.GetUpperBound(), 1) {
+			continue
+		}
+		bounds = append(bounds, otlpDouble(bucket.GetUpperBound()))
+		counts = append(counts, strconv.FormatUint(bucket.GetCumulativeCount()-below, 10))
+		below = bucket.GetCumulativeCount()
+	}
+	counts = append(counts, strconv.FormatUint(h.GetSampleCount()-below, 10))
+
+	point["count"] = strconv.FormatUint(h.GetSampleCount(), 10)
+	point["sum"] = otlpDouble(h.GetSampleSum())
+	point["bucketCounts"] = counts
+	point["explicitBounds"] = bounds
+}
+
+// otlpAttributes returns the attributes of the labels, sorted by name
+func otlpAttributes(labels map[string]string) []map[string]interface{} {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	attributes := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		attributes = append(attributes, map[string]interface{}{"key": name, "value": map[string]interface{}{"stringValue": labels[name]}})
+	}
+	return attributes
+}
+
+// otlpTime returns the time in nanoseconds since the epoch, 64 bits integers are strings in the JSON mapping
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// otlpDouble returns the value, or its string in the JSON mapping if it isn't finite, e.g. a stale gauge
+func otlpDouble(v float64) interface{} {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "Infinity"
+	case math.IsInf(v, -1):
+		return "-Infinity"
+	}
+	return v
+}
@@ -0,0 +1,116 @@
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// otlpReceiver returns a collector answering with status, the last request body is decoded in received
+func otlpReceiver(t *testing.T, status int, received *map[string]interface{}, header *http.Header) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*header = r.Header
+		if err := json.NewDecoder(r.Body).Decode(received); err != nil {
+			t.Errorf("failed to decode the request, got error: %v", err)
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// otlpMetrics returns the metrics of the request by name
+func otlpMetrics(request map[string]interface{}) map[string]map[string]interface{} {
+	metrics := map[string]map[string]interface{}{}
+	for _, rm := range request["resourceMetrics"].([]interface{}) {
+		for _, sm := range rm.(map[string]interface{})["scopeMetrics"].([]interface{}) {
+			for _, m := range sm.(map[string]interface{})["metrics"].([]interface{}) {
+				metric := m.(map[string]interface{})
+				metrics[metric["name"].(string)] = metric
+			}
+		}
+	}
+	return metrics
+}
+
+func TestOTLPExporter(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_otlp_total", Help: "Counter.", ConstLabels: prometheus.Labels{"db_name": "db1"}})
+	counter.Add(3)
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_otlp_stale", Help: "Stale gauge."})
+	gauge.Set(math.NaN())
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_otlp_seconds", Help: "Histogram.", Buckets: []float64{0.1, 1}})
+	for _, v := range []float64{0.05, 0.5, 0.7, 5} {
+		histogram.Observe(v)
+	}
+	registry.MustRegister(counter, gauge, histogram)
+
+	var (
+		received map[string]interface{}
+		header   http.Header
+	)
+	receiver := otlpReceiver(t, http.StatusOK, &received, &header)
+	exporter := &OTLPExporter{Endpoint: receiver.URL, Headers: http.Header{"api-key": {"secret"}}, Resource: map[string]string{"service.name": "orders"}}
+
+	if err := exporter.Export(context.Background(), registry); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if header.Get("Api-Key") != "secret" || header.Get("Content-Type") != "application/json" {
+		t.Errorf("expected the configured headers and JSON content type, got %v", header)
+	}
+
+	resource := received["resourceMetrics"].([]interface{})[0].(map[string]interface{})["resource"].(map[string]interface{})
+	if attributes, _ := json.Marshal(resource["attributes"]); string(attributes) != `[{"key":"service.name","value":{"stringValue":"orders"}}]` {
+		t.Errorf("unexpected resource attributes %s", attributes)
+	}
+
+	metrics := otlpMetrics(received)
+	sum := metrics["test_otlp_total"]["sum"].(map[string]interface{})
+	if sum["isMonotonic"] != true || sum["aggregationTemporality"] != float64(otlpCumulative) {
+		t.Errorf("expected a cumulative monotonic sum, got %v", sum)
+	}
+	point := sum["dataPoints"].([]interface{})[0].(map[string]interface{})
+	if point["asDouble"] != float64(3) {
+		t.Errorf("expected the counter value 3, got %v", point["asDouble"])
+	}
+	if attributes, _ := json.Marshal(point["attributes"]); string(attributes) != `[{"key":"db_name","value":{"stringValue":"db1"}}]` {
+		t.Errorf("unexpected data point attributes %s", attributes)
+	}
+
+	stale := metrics["test_otlp_stale"]["gauge"].(map[string]interface{})["dataPoints"].([]interface{})[0].(map[string]interface{})
+	if stale["asDouble"] != "NaN" {
+		t.Errorf("expected the stale gauge as NaN, got %v", stale["asDouble"])
+	}
+
+	buckets := metrics["test_otlp_seconds"]["histogram"].(map[string]interface{})["dataPoints"].([]interface{})[0].(map[string]interface{})
+	if counts, _ := json.Marshal(buckets["bucketCounts"]); string(counts) != `["1","2","1"]` {
+		t.Errorf("expected the observations of each bucket, got %s", counts)
+	}
+	if bounds, _ := json.Marshal(buckets["explicitBounds"]); string(bounds) != `[0.1,1]` {
+		t.Errorf("expected the bounds without +Inf, got %s", bounds)
+	}
+	if buckets["count"] != "4" {
+		t.Errorf("expected 4 observations, got %v", buckets["count"])
+	}
+}
+
+func TestOTLPExporterError(t *testing.T) {
+	var (
+		received map[string]interface{}
+		header   http.Header
+	)
+	receiver := otlpReceiver(t, http.StatusBadRequest, &received, &header)
+
+	err := (&OTLPExporter{Endpoint: receiver.URL}).Export(context.Background(), prometheus.NewRegistry())
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("expected the HTTP status in the error, got %v", err)
+	}
+}
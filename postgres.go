@@ -27,9 +27,11 @@ type Postgres struct {
 	Prefix        string
 	Interval      uint32
 	VariableNames []string
+	Registerer    prometheus.Registerer // registers the metrics, default the plugin registerer
 	gauges        map[string]prometheus.Gauge
 	counters      map[string]prometheus.Counter
 	lock          sync.RWMutex
+	metricsResult
 }
 
 func (m *Postgres) getGauge(identifier string) (prometheus.Gauge, bool) {
@@ -59,10 +61,6 @@ func (m *Postgres) setCounter(identifier string, c prometheus.Counter) {
 }
 
 func (m *Postgres) Metrics(p *Prometheus) []prometheus.Collector {
-	if m.Prefix == "" {
-		m.Prefix = "gorm_status_"
-	}
-
 	if m.Interval == 0 {
 		m.Interval = p.RefreshInterval
 	}
@@ -75,32 +73,7 @@ func (m *Postgres) Metrics(p *Prometheus) []prometheus.Collector {
 		m.counters = map[string]prometheus.Counter{}
 	}
 
-	funM := []func(*Prometheus, *sync.WaitGroup){
-		m.replicationLag,
-		m.postMasterStart,
-		m.pgStatUserTables,
-		m.pgStatIOUserTables,
-		m.size,
-		m.recordCount,
-	}
-
-	go func() {
-		for range time.Tick(time.Duration(m.Interval) * time.Second) {
-			var wg sync.WaitGroup
-			for _, f := range funM {
-				wg.Add(1)
-				go f(p, &wg)
-			}
-			wg.Wait()
-		}
-	}()
-
-	var wg sync.WaitGroup
-	for _, f := range funM {
-		wg.Add(1)
-		go f(p, &wg)
-	}
-	wg.Wait()
+	m.metricsErr = m.collect(p)
 
 	collectors := make([]prometheus.Collector, 0, len(m.gauges)+len(m.counters))
 
@@ -114,17 +87,61 @@ func (m *Postgres) Metrics(p *Prometheus) []prometheus.Collector {
 	return collectors
 }
 
-func (m *Postgres) replicationLag(p *Prometheus, wg *sync.WaitGroup) {
-	defer wg.Done()
+func (m *Postgres) RefreshInterval() uint32 {
+	return m.Interval
+}
+
+func (m *Postgres) Name() string {
+	return "postgres"
+}
+
+// Refresh runs the queries concurrently, their errors are logged and the first one is returned
+func (m *Postgres) Refresh(p *Prometheus) error {
+	return m.collect(p)
+}
 
+func (m *Postgres) collect(p *Prometheus) error {
+	funM := []func(*Prometheus) error{
+		m.replicationLag,
+		m.postMasterStart,
+		m.pgStatUserTables,
+		m.pgStatIOUserTables,
+		m.size,
+		m.recordCount,
+	}
+
+	var (
+		wg       sync.WaitGroup
+		errLock  sync.Mutex
+		firstErr error
+	)
+	for _, f := range funM {
+		wg.Add(1)
+		go func(f func(*Prometheus) error) {
+			defer wg.Done()
+			if err := f(p); err != nil {
+				errLock.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errLock.Unlock()
+			}
+		}(f)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+func (m *Postgres) replicationLag(p *Prometheus) error {
 	metric := "lag"
 
-	rows, err := p.DB.Raw("SELECT CASE WHEN NOT pg_is_in_recovery() THEN 0 ELSE GREATEST (0, EXTRACT(EPOCH FROM (now() - pg_last_xact_replay_timestamp()))) END AS lag").Rows()
+	rows, err := p.statusDB().Raw("SELECT CASE WHEN NOT pg_is_in_recovery() THEN 0 ELSE GREATEST (0, EXTRACT(EPOCH FROM (now() - pg_last_xact_replay_timestamp()))) END AS lag").Rows()
 
 	if err != nil {
 		p.DB.Logger.Error(context.Background(), "gorm:prometheus query error: %v", err)
-		return
+		return err
 	}
+	defer rows.Close()
 
 	var variableValue string
 	for rows.Next() {
@@ -143,28 +160,28 @@ func (m *Postgres) replicationLag(p *Prometheus, wg *sync.WaitGroup) {
 		gauge, ok := m.getGauge(metric)
 		if !ok {
 			gauge = prometheus.NewGauge(prometheus.GaugeOpts{
-				Name:        m.Prefix + metric,
-				ConstLabels: p.Labels,
-				Help:        "Replication lag behind master in seconds",
+				Name:        p.statusName(m.Prefix, metric),
+				ConstLabels: p.collectorLabels(m),
+				Help:        p.help(p.statusName(m.Prefix, metric), "Replication lag behind master in seconds"),
 			})
 
 			m.setGauge(metric, gauge)
-			prometheus.Register(gauge)
+			_ = p.registerer(m.Registerer).Register(gauge)
 		}
 		gauge.Set(value)
 	}
+	return nil
 }
 
-func (m *Postgres) postMasterStart(p *Prometheus, wg *sync.WaitGroup) {
-	defer wg.Done()
-
+func (m *Postgres) postMasterStart(p *Prometheus) error {
 	metric := "start_time_seconds"
-	rows, err := p.DB.Raw("SELECT pg_postmaster_start_time as start_time_seconds from pg_postmaster_start_time()").Rows()
+	rows, err := p.statusDB().Raw("SELECT pg_postmaster_start_time as start_time_seconds from pg_postmaster_start_time()").Rows()
 
 	if err != nil {
 		p.DB.Logger.Error(context.Background(), "gorm:prometheus query error: %v", err)
-		return
+		return err
 	}
+	defer rows.Close()
 
 	var variableValue string
 	for rows.Next() {
@@ -183,33 +200,33 @@ func (m *Postgres) postMasterStart(p *Prometheus, wg *sync.WaitGroup) {
 		gauge, ok := m.getGauge(metric)
 		if !ok {
 			gauge = prometheus.NewGauge(prometheus.GaugeOpts{
-				Name:        m.Prefix + metric,
-				ConstLabels: p.Labels,
-				Help:        "Time unix timestamp at which postmaster started",
+				Name:        p.statusName(m.Prefix, metric),
+				ConstLabels: p.collectorLabels(m),
+				Help:        p.help(p.statusName(m.Prefix, metric), "Unix timestamp in seconds at which postmaster started"),
 			})
 
 			m.setGauge(metric, gauge)
-			prometheus.Register(gauge)
+			_ = p.registerer(m.Registerer).Register(gauge)
 		}
 
 		gauge.Set(float64(value.Unix()))
 	}
+	return nil
 }
 
-func (m *Postgres) size(p *Prometheus, wg *sync.WaitGroup) {
-	defer wg.Done()
-
+func (m *Postgres) size(p *Prometheus) error {
 	type data struct {
 		DatName   string `gorm:"column:datname" type:"label" help:"Name of current database"`
 		SizeBytes int64  `gorm:"column:size_bytes" type:"gauge" help:"Size of database in bytes"`
 	}
 
-	rows, err := p.DB.Raw("SELECT pg_database.datname, pg_database_size(pg_database.datname) as size_bytes FROM pg_database").Rows()
+	rows, err := p.statusDB().Raw("SELECT pg_database.datname, pg_database_size(pg_database.datname) as size_bytes FROM pg_database").Rows()
 
 	if err != nil {
 		p.DB.Logger.Error(context.Background(), "gorm:prometheus query error: %v", err)
-		return
+		return err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var r data
@@ -224,11 +241,10 @@ func (m *Postgres) size(p *Prometheus, wg *sync.WaitGroup) {
 
 		m._parse(t, v, p)
 	}
+	return nil
 }
 
-func (m *Postgres) pgStatUserTables(p *Prometheus, wg *sync.WaitGroup) {
-	defer wg.Done()
-
+func (m *Postgres) pgStatUserTables(p *Prometheus) error {
 	type data struct {
 		DatName              string    `gorm:"column:datname" type:"label" help:"Name of current database"`
 		SchemaName           string    `gorm:"column:schemaname" type:"label" help:"Name of the schema that this table is in"`
@@ -244,17 +260,17 @@ func (m *Postgres) pgStatUserTables(p *Prometheus, wg *sync.WaitGroup) {
 		NLiveTup             int64     `gorm:"column:n_live_tup" type:"gauge" help:"Estimated number of live rows"`
 		NDeadTup             int64     `gorm:"column:n_dead_tup" type:"gauge" help:"Estimated number of dead rows"`
 		NModSinceLastAnalyze int64     `gorm:"column:n_mod_since_last_analyze" type:"gauge" help:"Estimated number of rows changed since last analyze"`
-		LastVacum            time.Time `gorm:"column:last_vacuum" type:"gauge" help:"Last time at which this table was manually vacuumed (not counting VACUUM FULL)"`
-		LastAutovacum        time.Time `gorm:"column:last_autovacuum" type:"gauge" help:"Last time at which this table was vacuumed by the autovacuum daemon"`
-		LastAnalyze          time.Time `gorm:"column:last_analyze" type:"gauge" help:"Last time at which this table was manually analyzed"`
-		LatAutoAnalyze       time.Time `gorm:"column:last_autoanalyze" type:"gauge" help:"Last time at which this table was analyzed by the autovacuum daemon"`
+		LastVacum            time.Time `gorm:"column:last_vacuum" type:"gauge" help:"Last time at which this table was manually vacuumed (not counting VACUUM FULL), as unix timestamp in seconds"`
+		LastAutovacum        time.Time `gorm:"column:last_autovacuum" type:"gauge" help:"Last time at which this table was vacuumed by the autovacuum daemon, as unix timestamp in seconds"`
+		LastAnalyze          time.Time `gorm:"column:last_analyze" type:"gauge" help:"Last time at which this table was manually analyzed, as unix timestamp in seconds"`
+		LatAutoAnalyze       time.Time `gorm:"column:last_autoanalyze" type:"gauge" help:"Last time at which this table was analyzed by the autovacuum daemon, as unix timestamp in seconds"`
 		VacumCount           int64     `gorm:"column:vacuum_count" type:"counter" help:"Number of times this table has been manually vacuumed (not counting VACUUM FULL)"`
 		AutoVacuumCount      int64     `gorm:"column:autovacuum_count" type:"counter" help:"Number of times this table has been vacuumed by the autovacuum daemon"`
 		AnalyzeCount         int64     `gorm:"column:analyze_count" type:"counter" help:"Number of times this table has been manually analyzed"`
 		AutoAnalyzeCount     int64     `gorm:"column:autoanalyze_count" type:"counter" help:"Number of times this table has been analyzed by the autovacuum daemon"`
 	}
 
-	rows, err := p.DB.Raw(`
+	rows, err := p.statusDB().Raw(`
   SELECT
 	current_database() datname,
 	schemaname,
@@ -283,8 +299,9 @@ func (m *Postgres) pgStatUserTables(p *Prometheus, wg *sync.WaitGroup) {
 
 	if err != nil {
 		p.DB.Logger.Error(context.Background(), "gorm:prometheus query error: %v", err)
-		return
+		return err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var r data
@@ -299,11 +316,10 @@ func (m *Postgres) pgStatUserTables(p *Prometheus, wg *sync.WaitGroup) {
 
 		m._parse(t, v, p)
 	}
+	return nil
 }
 
-func (m *Postgres) pgStatIOUserTables(p *Prometheus, wg *sync.WaitGroup) {
-	defer wg.Done()
-
+func (m *Postgres) pgStatIOUserTables(p *Prometheus) error {
 	type data struct {
 		DatName       string `gorm:"column:datname" type:"label" help:"Name of current database"`
 		SchemaName    string `gorm:"column:schemaname" type:"label" help:"Name of the schema that this table is in"`
@@ -318,7 +334,7 @@ func (m *Postgres) pgStatIOUserTables(p *Prometheus, wg *sync.WaitGroup) {
 		TidxBlksHit   int64  `gorm:"column:toast_idx_blks_hit" type:"counter" help:"Number of buffer hits in this table's TOAST table indexes (if any)"`
 	}
 
-	rows, err := p.DB.Raw(`SELECT 
+	rows, err := p.statusDB().Raw(`SELECT 
 	 current_database() datname,
 	 schemaname, 
 	 relname, 
@@ -335,8 +351,9 @@ func (m *Postgres) pgStatIOUserTables(p *Prometheus, wg *sync.WaitGroup) {
 
 	if err != nil {
 		p.DB.Logger.Error(context.Background(), "gorm:prometheus query error: %v", err)
-		return
+		return err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var r data
@@ -351,23 +368,23 @@ func (m *Postgres) pgStatIOUserTables(p *Prometheus, wg *sync.WaitGroup) {
 
 		m._parse(t, v, p)
 	}
+	return nil
 }
 
-func (m *Postgres) recordCount(p *Prometheus, wg *sync.WaitGroup) {
-	defer wg.Done()
-
+func (m *Postgres) recordCount(p *Prometheus) error {
 	type data struct {
 		DatName    string `gorm:"column:table_schema" type:"label" help:"Name of current database"`
 		SchemaName string `gorm:"column:table_name" type:"label" help:"Name of the schema that this table is in"`
-		RowsCount  int64  `gorm:"column:rows_count" type:"gauge" help:"Name of this table"`
+		RowsCount  int64  `gorm:"column:rows_count" type:"gauge" help:"Number of rows in this table"`
 	}
 
-	rows, err := p.DB.Raw(`with tbl as (SELECT table_schema,table_name FROM information_schema.tables   where table_name not like 'pg_%' and table_schema in ('public'))   select table_schema, table_name, (xpath('/row/c/text()', query_to_xml(format('select count(*) as c from %I.%I', table_schema, table_name), false, true, '')))[1]::text::int as rows_count from tbl ORDER BY 3 DESC;`).Rows()
+	rows, err := p.statusDB().Raw(`with tbl as (SELECT table_schema,table_name FROM information_schema.tables   where table_name not like 'pg_%' and table_schema in ('public'))   select table_schema, table_name, (xpath('/row/c/text()', query_to_xml(format('select count(*) as c from %I.%I', table_schema, table_name), false, true, '')))[1]::text::int as rows_count from tbl ORDER BY 3 DESC;`).Rows()
 
 	if err != nil {
 		p.DB.Logger.Error(context.Background(), "gorm:prometheus query error: %v", err)
-		return
+		return err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var r data
@@ -382,6 +399,7 @@ func (m *Postgres) recordCount(p *Prometheus, wg *sync.WaitGroup) {
 
 		m._parse(t, v, p)
 	}
+	return nil
 }
 
 // _parse parses the data per database ROW and registers it for sending
@@ -396,6 +414,7 @@ func (m *Postgres) _parse(t reflect.Type, v reflect.Value, p *Prometheus) {
 			labels[strings.TrimPrefix(field.Tag.Get("gorm"), "column:")] = v.Field(i).String()
 		}
 	}
+	labels = p.transformLabels(labels)
 
 	// emit the metrics
 	for i := 0; i < t.NumField(); i++ {
@@ -419,32 +438,32 @@ func (m *Postgres) _parse(t reflect.Type, v reflect.Value, p *Prometheus) {
 			// field name + labels are unique status identifier
 			_, ok := m.getGauge(identifier)
 			if !ok {
-				for k, v := range p.Labels {
+				for k, v := range p.collectorLabels(m) {
 					labels[k] = v
 				}
 				g := prometheus.NewGauge(prometheus.GaugeOpts{
-					Name:        m.Prefix + metric,
+					Name:        p.statusName(m.Prefix, metric),
 					ConstLabels: labels,
-					Help:        field.Tag.Get("help"),
+					Help:        p.help(p.statusName(m.Prefix, metric), field.Tag.Get("help")),
 				})
 
 				m.setGauge(identifier, g)
-				prometheus.Register(g)
+				_ = p.registerer(m.Registerer).Register(g)
 			}
 		case "counter":
 			_, ok := m.getCounter(identifier)
 			if !ok {
-				for k, v := range p.Labels {
+				for k, v := range p.collectorLabels(m) {
 					labels[k] = v
 				}
 				c := prometheus.NewCounter(prometheus.CounterOpts{
-					Name:        m.Prefix + metric,
+					Name:        p.statusName(m.Prefix, metric),
 					ConstLabels: labels,
-					Help:        field.Tag.Get("help"),
+					Help:        p.help(p.statusName(m.Prefix, metric), field.Tag.Get("help")),
 				})
 
 				m.setCounter(identifier, c)
-				prometheus.Register(c)
+				_ = p.registerer(m.Registerer).Register(c)
 			}
 		default:
 			p.DB.Logger.Error(context.Background(), "gorm:prometheus unhandled type: %s", tag)
@@ -0,0 +1,96 @@
+package prometheus
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// postgresConnectionStates are the state labels of PostgresConnections, other states, e.g. fastpath function call
+// or disabled, are counted as "other"
+var postgresConnectionStates = []string{"active", "idle", "idle_in_transaction", "idle_in_transaction_aborted", "other"}
+
+// PostgresConnections exposes the server side connections to the current database by state from pg_stat_activity,
+// connections idle in transaction for long usually are leaked transactions
+type PostgresConnections struct {
+	Prefix      string
+	Interval    uint32
+	Registerer  prometheus.Registerer // registers the metrics, default the plugin registerer
+	connections *prometheus.GaugeVec
+	once        sync.Once
+	metricsResult
+}
+
+func (m *PostgresConnections) Metrics(p *Prometheus) []prometheus.Collector {
+	if m.Interval == 0 {
+		m.Interval = p.RefreshInterval
+	}
+
+	m.once.Do(func() {
+		m.connections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        p.statusName(m.Prefix, "connections"),
+			Help:        p.help(p.statusName(m.Prefix, "connections"), "The number of connections to the current database by state, from pg_stat_activity."),
+			ConstLabels: p.collectorLabels(m),
+		}, p.transformLabelNames([]string{"state"}))
+		_ = p.registerer(m.Registerer).Register(m.connections)
+	})
+
+	m.metricsErr = m.collect(p)
+	return []prometheus.Collector{m.connections}
+}
+
+func (m *PostgresConnections) RefreshInterval() uint32 {
+	return m.Interval
+}
+
+func (m *PostgresConnections) Name() string {
+	return "postgres_connections"
+}
+
+func (m *PostgresConnections) Refresh(p *Prometheus) error {
+	return m.collect(p)
+}
+
+func (m *PostgresConnections) collect(p *Prometheus) error {
+	rows, err := p.statusDB().Raw("SELECT state, count(*) FROM pg_stat_activity WHERE datname = current_database() AND backend_type = 'client backend' GROUP BY state").Rows()
+	if err != nil {
+		p.DB.Logger.Error(context.Background(), "gorm:prometheus query error: %v", err)
+		return err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]float64, len(postgresConnectionStates))
+	for _, state := range postgresConnectionStates {
+		counts[state] = 0 // states without connections are reported as 0
+	}
+
+	for rows.Next() {
+		var (
+			state sql.NullString // NULL when the current user can't see the state
+			count float64
+		)
+		if err = rows.Scan(&state, &count); err != nil {
+			p.DB.Logger.Error(context.Background(), "gorm:prometheus scan got error: %v", err)
+			return err
+		}
+
+		label := strings.Replace(strings.Replace(state.String, " ", "_", -1), "(aborted)", "aborted", 1)
+		if _, ok := counts[label]; !ok {
+			label = "other"
+		}
+		counts[label] += count
+	}
+
+	if err = rows.Err(); err != nil {
+		p.DB.Logger.Error(context.Background(), "gorm:prometheus rows got error: %v", err)
+		return err
+	}
+
+	for state, count := range counts {
+		m.connections.With(p.transformLabels(map[string]string{"state": state})).Set(count)
+	}
+	return nil
+}
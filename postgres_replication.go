@@ -0,0 +1,84 @@
+package prometheus
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PostgresReplication exposes the replication lag of a Postgres standby, nothing is emitted while the
+// instance is not in recovery or hasn't replayed a transaction yet
+type PostgresReplication struct {
+	Prefix     string
+	Interval   uint32
+	Registerer prometheus.Registerer // registers the metrics, default the plugin registerer
+	lag        prometheus.Gauge
+	lock       sync.Mutex
+	metricsResult
+}
+
+func (m *PostgresReplication) Metrics(p *Prometheus) []prometheus.Collector {
+	if m.Interval == 0 {
+		m.Interval = p.RefreshInterval
+	}
+
+	m.metricsErr = m.collect(p)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.lag == nil {
+		return nil
+	}
+	return []prometheus.Collector{m.lag}
+}
+
+func (m *PostgresReplication) RefreshInterval() uint32 {
+	return m.Interval
+}
+
+func (m *PostgresReplication) Name() string {
+	return "replication"
+}
+
+func (m *PostgresReplication) Refresh(p *Prometheus) error {
+	return m.collect(p)
+}
+
+func (m *PostgresReplication) collect(p *Prometheus) error {
+	var (
+		inRecovery bool
+		lag        sql.NullFloat64
+	)
+
+	err := p.statusDB().Raw("SELECT pg_is_in_recovery(), EXTRACT(EPOCH FROM (now() - pg_last_xact_replay_timestamp()))").Row().Scan(&inRecovery, &lag)
+	if err != nil {
+		p.DB.Logger.Error(context.Background(), "gorm:prometheus query error: %v", err)
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	// the replay timestamp is NULL on a primary and until a standby has replayed a transaction, the lag isn't
+	// exposed then rather than read as caught up
+	if !inRecovery || !lag.Valid {
+		if m.lag != nil {
+			p.registerer(m.Registerer).Unregister(m.lag)
+			m.lag = nil
+		}
+		return nil
+	}
+
+	if m.lag == nil {
+		m.lag = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        p.statusName(m.Prefix, "replication_lag_seconds"),
+			Help:        p.help(p.statusName(m.Prefix, "replication_lag_seconds"), "Time since the last transaction replayed from the primary, in seconds."),
+			ConstLabels: p.collectorLabels(m),
+		})
+		_ = p.registerer(m.Registerer).Register(m.lag)
+	}
+	m.lag.Set(lag.Float64)
+	return nil
+}
@@ -0,0 +1,48 @@
+package prometheus
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gorm.io/gorm"
+)
+
+func replicationRows(inRecovery bool, lag interface{}) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"pg_is_in_recovery", "lag"}).AddRow(inRecovery, lag)
+}
+
+func TestPostgresReplication(t *testing.T) {
+	db, mock := newMockGorm(t, &gorm.Config{})
+	registry := prometheus.NewRegistry()
+	p := New(Config{Registry: registry})
+	p.DB = db
+
+	m := &PostgresReplication{Prefix: "test_pg_replication_"}
+
+	mock.ExpectQuery("pg_is_in_recovery").WillReturnRows(replicationRows(true, nil))
+	if collectors := m.Metrics(p); len(collectors) != 0 {
+		t.Errorf("expected no lag before a transaction is replayed, got %d collectors", len(collectors))
+	}
+
+	mock.ExpectQuery("pg_is_in_recovery").WillReturnRows(replicationRows(true, 2.5))
+	if err := m.Refresh(p); err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	if v := testutil.ToFloat64(m.lag); v != 2.5 {
+		t.Errorf("expected a lag of 2.5s, got %v", v)
+	}
+
+	mock.ExpectQuery("pg_is_in_recovery").WillReturnRows(replicationRows(false, nil))
+	if err := m.Refresh(p); err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	if n, err := testutil.GatherAndCount(registry, "test_pg_replication_replication_lag_seconds"); err != nil || n != 0 {
+		t.Errorf("expected no lag once promoted, got %d, %v", n, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
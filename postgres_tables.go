@@ -0,0 +1,131 @@
+package prometheus
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultPostgresTablesInterval = 300 // dead tuples build up slowly, refresh every 5 minutes
+
+// PostgresTables exposes the live and dead tuples and the last autovacuum and autoanalyze of the tables listed in
+// Tables from pg_stat_user_tables, a high count of dead tuples indicates vacuum doesn't keep up. Only the listed
+// tables are exposed, nothing without Tables
+type PostgresTables struct {
+	Prefix     string
+	Interval   uint32                // default 300 seconds
+	Tables     []string              // tables exposed, e.g. "orders" in any schema or "public.orders"
+	Registerer prometheus.Registerer // registers the metrics, default the plugin registerer
+
+	live, dead                  *prometheus.GaugeVec
+	lastAutovacuum, lastAnalyze *prometheus.GaugeVec
+	once                        sync.Once
+	metricsResult
+}
+
+func (m *PostgresTables) Metrics(p *Prometheus) []prometheus.Collector {
+	if m.Interval == 0 {
+		m.Interval = defaultPostgresTablesInterval
+	}
+
+	m.once.Do(func() {
+		gaugeVec := func(name, help string) *prometheus.GaugeVec {
+			vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name:        p.statusName(m.Prefix, name),
+				Help:        p.help(p.statusName(m.Prefix, name), help),
+				ConstLabels: p.collectorLabels(m),
+			}, p.transformLabelNames([]string{"schema", "table"}))
+			_ = p.registerer(m.Registerer).Register(vec)
+			return vec
+		}
+
+		m.live = gaugeVec("table_live_tuples", "Estimated number of live rows of the table, from pg_stat_user_tables.")
+		m.dead = gaugeVec("table_dead_tuples", "Estimated number of dead rows of the table, from pg_stat_user_tables.")
+		m.lastAutovacuum = gaugeVec("table_last_autovacuum_timestamp_seconds", "Time of the last autovacuum of the table, in seconds since the epoch.")
+		m.lastAnalyze = gaugeVec("table_last_autoanalyze_timestamp_seconds", "Time of the last autoanalyze of the table, in seconds since the epoch.")
+	})
+
+	m.metricsErr = m.collect(p)
+	return []prometheus.Collector{m.live, m.dead, m.lastAutovacuum, m.lastAnalyze}
+}
+
+func (m *PostgresTables) RefreshInterval() uint32 {
+	return m.Interval
+}
+
+func (m *PostgresTables) Name() string {
+	return "postgres_tables"
+}
+
+func (m *PostgresTables) Refresh(p *Prometheus) error {
+	return m.collect(p)
+}
+
+func (m *PostgresTables) collect(p *Prometheus) error {
+	if len(m.Tables) == 0 {
+		return nil
+	}
+
+	db, err := p.statusSQLDB()
+	if err != nil {
+		p.DB.Logger.Error(context.Background(), "gorm:prometheus failed to get db, got error: %v", err)
+		return err
+	}
+
+	listed := make(map[string]bool, len(m.Tables))
+	names := make([]interface{}, len(m.Tables))
+	placeholders := make([]string, len(m.Tables))
+	for i, table := range m.Tables {
+		listed[table] = true
+		names[i] = table[strings.LastIndex(table, ".")+1:]
+		placeholders[i] = "$" + strconv.Itoa(i+1)
+	}
+
+	rows, err := db.QueryContext(context.Background(), "SELECT schemaname, relname, n_live_tup, n_dead_tup, EXTRACT(EPOCH FROM last_autovacuum), "+
+		"EXTRACT(EPOCH FROM last_autoanalyze) FROM pg_stat_user_tables WHERE relname IN ("+strings.Join(placeholders, ", ")+")", names...)
+	if err != nil {
+		p.DB.Logger.Error(context.Background(), "gorm:prometheus query error: %v", err)
+		return err
+	}
+	defer rows.Close()
+
+	// tables dropped since the previous refresh aren't exposed anymore
+	for _, vec := range []*prometheus.GaugeVec{m.live, m.dead, m.lastAutovacuum, m.lastAnalyze} {
+		vec.Reset()
+	}
+
+	for rows.Next() {
+		var (
+			schema, table               string
+			live, dead                  float64
+			lastAutovacuum, lastAnalyze sql.NullFloat64 // NULL until the table is autovacuumed or autoanalyzed
+		)
+		if err = rows.Scan(&schema, &table, &live, &dead, &lastAutovacuum, &lastAnalyze); err != nil {
+			p.DB.Logger.Error(context.Background(), "gorm:prometheus scan got error: %v", err)
+			return err
+		}
+
+		if !listed[table] && !listed[schema+"."+table] {
+			continue // same name in a schema not listed
+		}
+
+		labels := p.transformLabels(map[string]string{"schema": schema, "table": table})
+		m.live.With(labels).Set(live)
+		m.dead.With(labels).Set(dead)
+		if lastAutovacuum.Valid {
+			m.lastAutovacuum.With(labels).Set(lastAutovacuum.Float64)
+		}
+		if lastAnalyze.Valid {
+			m.lastAnalyze.With(labels).Set(lastAnalyze.Float64)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		p.DB.Logger.Error(context.Background(), "gorm:prometheus rows got error: %v", err)
+	}
+	return err
+}
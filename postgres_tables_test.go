@@ -0,0 +1,35 @@
+package prometheus
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPostgresTables(t *testing.T) {
+	p, mock := newMockPrometheus(t)
+	mock.ExpectQuery("FROM pg_stat_user_tables").WithArgs("orders", "users").WillReturnRows(
+		sqlmock.NewRows([]string{"schemaname", "relname", "n_live_tup", "n_dead_tup", "last_autovacuum", "last_autoanalyze"}).
+			AddRow("public", "orders", 1000, 250, 1.7e9, nil).
+			AddRow("archive", "users", 10, 0, nil, nil).
+			AddRow("public", "users", 20, 5, nil, 1.7e9),
+	)
+
+	m := &PostgresTables{Prefix: "test_pg_tables_", Tables: []string{"orders", "public.users"}}
+	m.Metrics(p)
+
+	if v := testutil.ToFloat64(m.dead.WithLabelValues("public", "orders")); v != 250 {
+		t.Errorf("expected 250 dead tuples, got %v", v)
+	}
+	if n := testutil.CollectAndCount(m.live); n != 2 {
+		t.Errorf("archive.users isn't listed, expected 2 tables, got %d", n)
+	}
+	if n := testutil.CollectAndCount(m.lastAutovacuum); n != 1 {
+		t.Errorf("expected the autovacuum of orders only, got %d", n)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package prometheus
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+const (
+	preparedStmtConnPoolKey = "gorm:prometheus:prepared_stmt_conn_pool"
+	executionKey            = "gorm:prometheus:execution"
+)
+
+// preparedStmtDB returns the prepared statement cache used by the conn pool, nil if statements aren't prepared
+func preparedStmtDB(connPool gorm.ConnPool) *gorm.PreparedStmtDB {
+	switch cp := connPool.(type) {
+	case *gorm.PreparedStmtDB:
+		return cp
+	case *gorm.PreparedStmtTX:
+		return cp.PreparedStmtDB
+	}
+	return nil
+}
+
+// preparedStmtConnPool checks the prepared statement cache before executing a query,
+// it replaces the statement conn pool only while the SQL callback runs
+type preparedStmtConnPool struct {
+	gorm.ConnPool
+	cache     *gorm.PreparedStmtDB
+	stats     *QueryStats
+	table     string // table of the statement, labeling the statements prepared
+	execution string // "cached" or "prepared" once the query is looked up
+}
+
+func (cp *preparedStmtConnPool) lookup(query string) {
+	cp.cache.Mux.RLock()
+	_, ok := cp.cache.Stmts[query]
+	cp.cache.Mux.RUnlock()
+
+	if ok {
+		cp.execution = "cached"
+	} else {
+		cp.execution = "prepared"
+	}
+
+	if cp.stats.PreparedStmtHits == nil { // only looked up for ExecutionLabel
+		return
+	}
+	if ok {
+		cp.stats.PreparedStmtHits.Inc()
+	} else {
+		cp.stats.PreparedStmtMisses.Inc()
+		cp.stats.preparedStmtTable(query, cp.table)
+	}
+}
+
+func (cp *preparedStmtConnPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	cp.lookup(query)
+	return cp.ConnPool.ExecContext(ctx, query, args...)
+}
+
+func (cp *preparedStmtConnPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	cp.lookup(query)
+	return cp.ConnPool.QueryContext(ctx, query, args...)
+}
+
+func (cp *preparedStmtConnPool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	cp.lookup(query)
+	return cp.ConnPool.QueryRowContext(ctx, query, args...)
+}
+
+func (stats *QueryStats) beforePreparedStmt(db *gorm.DB) {
+	if cache := preparedStmtDB(db.Statement.ConnPool); cache != nil {
+		db.InstanceSet(preparedStmtConnPoolKey, db.Statement.ConnPool)
+		db.Statement.ConnPool = &preparedStmtConnPool{ConnPool: db.Statement.ConnPool, cache: cache, stats: stats, table: db.Statement.Table}
+	}
+}
+
+func (stats *QueryStats) afterPreparedStmt(db *gorm.DB) {
+	if connPool, ok := db.InstanceGet(preparedStmtConnPoolKey); ok && connPool != nil {
+		if cp, ok := db.Statement.ConnPool.(*preparedStmtConnPool); ok && cp.execution != "" {
+			db.InstanceSet(executionKey, cp.execution)
+		}
+		db.Statement.ConnPool = connPool.(gorm.ConnPool)
+		db.InstanceSet(preparedStmtConnPoolKey, nil)
+	}
+}
+
+func newPreparedStmtCounters(labels map[string]string, config *Config) (hits, misses prometheus.Counter) {
+	hits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        config.metricName("", "prepared_stmt_cache_hits_total"),
+		Help:        config.help(config.metricName("", "prepared_stmt_cache_hits_total"), "The total number of queries executed with a cached prepared statement."),
+		ConstLabels: labels,
+	})
+	misses = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        config.metricName("", "prepared_stmt_cache_misses_total"),
+		Help:        config.help(config.metricName("", "prepared_stmt_cache_misses_total"), "The total number of queries that prepared a new statement."),
+		ConstLabels: labels,
+	})
+	return hits, misses
+}
+
+func newPreparedStmts(labels map[string]string, config *Config) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        config.metricName("", "prepared_stmts"),
+		Help:        config.help(config.metricName("", "prepared_stmts"), "The number of statements in the prepared statement cache, by table."),
+		ConstLabels: labels,
+	}, config.transformLabelNames([]string{"table"}))
+}
+
+// preparedStmtTable records the table of the query prepared, "other" for raw SQL and the tables not labeled by
+// TableLabels
+func (stats *QueryStats) preparedStmtTable(query, table string) {
+	if table == "" || (len(stats.tables) > 0 && !stats.tables[table]) {
+		table = "other"
+	}
+
+	stats.stmtTablesLock.Lock()
+	stats.stmtTables[query] = table
+	stats.stmtTablesLock.Unlock()
+}
+
+// refreshPreparedStmts counts the statements of the cache by table, the statements gorm removed from the cache,
+// e.g. on a bad connection, are forgotten
+func (stats *QueryStats) refreshPreparedStmts(cache *gorm.PreparedStmtDB) {
+	counts := map[string]float64{}
+
+	stats.stmtTablesLock.Lock()
+	cache.Mux.RLock()
+	for query, table := range stats.stmtTables {
+		if _, ok := cache.Stmts[query]; ok {
+			counts[table]++
+		} else {
+			delete(stats.stmtTables, query)
+		}
+	}
+	cached := len(cache.Stmts)
+	cache.Mux.RUnlock()
+	stats.stmtTablesLock.Unlock()
+
+	// statements prepared before the plugin was initialized or by another session have no known table
+	for _, count := range counts {
+		cached -= int(count)
+	}
+	if cached > 0 {
+		counts["other"] += float64(cached)
+	}
+
+	stats.PreparedStmts.Reset()
+	for table, count := range counts {
+		stats.PreparedStmts.With(stats.config.transformLabels(map[string]string{"table": table})).Set(count)
+	}
+}
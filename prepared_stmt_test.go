@@ -0,0 +1,76 @@
+package prometheus
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gorm.io/gorm"
+)
+
+func TestPreparedStmtMetrics(t *testing.T) {
+	db, mock := newMockGorm(t, &gorm.Config{PrepareStmt: true})
+	p := usePlugin(t, db, Config{CallbackMetrics: true, PreparedStmtMetrics: true})
+
+	mock.ExpectPrepare("SELECT").ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "jinzhu"))
+	mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "jinzhu"))
+
+	for i := 0; i < 2; i++ {
+		if err := db.Table("users").Where("id = ?", 1).Find(&[]map[string]interface{}{}).Error; err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+	}
+
+	if v := testutil.ToFloat64(p.QueryStats.PreparedStmtMisses); v != 1 {
+		t.Errorf("expected 1 miss preparing the statement, got %v", v)
+	}
+	if v := testutil.ToFloat64(p.QueryStats.PreparedStmtHits); v != 1 {
+		t.Errorf("expected 1 hit of the cached statement, got %v", v)
+	}
+
+	p.refresh()
+	if v := testutil.ToFloat64(p.QueryStats.PreparedStmts.WithLabelValues("users")); v != 1 {
+		t.Errorf("expected 1 statement cached for users, got %v", v)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestPreparedStmtMetricsDisabled(t *testing.T) {
+	_, _, p := newMockDB(t, Config{CallbackMetrics: true, PreparedStmtMetrics: true})
+	if p.QueryStats.PreparedStmtHits != nil {
+		t.Errorf("expected no prepared statement metrics without PrepareStmt")
+	}
+}
+
+func TestExecutionLabel(t *testing.T) {
+	db, mock := newMockGorm(t, &gorm.Config{PrepareStmt: true})
+	p := usePlugin(t, db, Config{CallbackMetrics: true, ExecutionLabel: true})
+
+	mock.ExpectPrepare("SELECT").ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	for i := 0; i < 2; i++ {
+		if err := db.Table("users").Where("id = ?", 1).Find(&[]map[string]interface{}{}).Error; err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+	}
+
+	for execution, expected := range map[string]float64{"prepared": 1, "cached": 1} {
+		if v := testutil.ToFloat64(p.QueryStats.Queries.With(prometheus.Labels{"operation": "query", "execution": execution})); v != expected {
+			t.Errorf("expected %v %s queries, got %v", expected, execution, v)
+		}
+	}
+
+	direct, mock, directP := newMockDB(t, Config{CallbackMetrics: true, ExecutionLabel: true})
+	mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	if err := direct.Table("users").Find(&[]map[string]interface{}{}).Error; err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if v := testutil.ToFloat64(directP.QueryStats.Queries.With(prometheus.Labels{"operation": "query", "execution": "direct"})); v != 1 {
+		t.Errorf("expected 1 direct query without PrepareStmt, got %v", v)
+	}
+}
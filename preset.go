@@ -0,0 +1,46 @@
+package prometheus
+
+// PresetSQLStats names the DBStats metrics like github.com/dlmiddlecote/sqlstats, e.g.
+// go_sql_stats_connections_open, as expected by the community Grafana dashboards for database/sql pools.
+// The wait duration is exposed in seconds, the other metrics keep their native names.
+const PresetSQLStats = "sqlstats"
+
+var sqlStatsNames = map[string]string{
+	"max_open_connections": "go_sql_stats_connections_max_open",
+	"open_connections":     "go_sql_stats_connections_open",
+	"in_use":               "go_sql_stats_connections_in_use",
+	"idle":                 "go_sql_stats_connections_idle",
+	"wait_count":           "go_sql_stats_connections_waited_for",
+	"wait_duration":        "go_sql_stats_connections_blocked_seconds",
+	"max_idle_closed":      "go_sql_stats_connections_closed_max_idle",
+	"max_lifetime_closed":  "go_sql_stats_connections_closed_max_lifetime",
+	"max_idletime_closed":  "go_sql_stats_connections_closed_max_idle_time",
+
+	// the counters, sqlstats names them without _total
+	"wait_count_total":            "go_sql_stats_connections_waited_for",
+	"wait_duration_seconds_total": "go_sql_stats_connections_blocked_seconds",
+	"max_idle_closed_total":       "go_sql_stats_connections_closed_max_idle",
+	"max_lifetime_closed_total":   "go_sql_stats_connections_closed_max_lifetime",
+	"max_idletime_closed_total":   "go_sql_stats_connections_closed_max_idle_time",
+}
+
+// applyPreset wraps the MetricNamer to name the metrics of the preset
+func (c *Config) applyPreset() {
+	if c.Preset != PresetSQLStats {
+		return
+	}
+
+	namer := c.MetricNamer
+	if namer == nil {
+		namer = DefaultMetricNamer
+	}
+
+	c.MetricNamer = func(component, name string) string {
+		if component == "dbstats" {
+			if presetName, ok := sqlStatsNames[name]; ok {
+				return presetName
+			}
+		}
+		return namer(component, name)
+	}
+}
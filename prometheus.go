@@ -2,14 +2,21 @@ package prometheus
 
 import (
 	"context"
+	"crypto/tls"
+	"database/sql"
 	"fmt"
+	"io"
+	"log"
+	"math"
 	"net/http"
+	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"gorm.io/gorm"
 )
 
@@ -20,17 +27,61 @@ var (
 const (
 	defaultRefreshInterval = 15   // the prometheus default pull metrics every 15 seconds
 	defaultHTTPServerPort  = 8080 // default pull port
+
+	defaultMaxOrigins = 100
+	defaultMaxTables  = 100
 )
 
 type MetricsCollector interface {
 	Metrics(*Prometheus) []prometheus.Collector
 }
 
+// Refresher is implemented by MetricsCollectors refreshed by the plugin, each collector is scheduled
+// independently every RefreshInterval seconds, 0 means the plugin RefreshInterval
+type Refresher interface {
+	RefreshInterval() uint32
+	Refresh(*Prometheus) error
+}
+
+// Named is implemented by MetricsCollectors labeled by CollectorLabel, the name must be stable, e.g. "mysql"
+type Named interface {
+	Name() string
+}
+
 type Prometheus struct {
 	*gorm.DB
 	*DBStats
 	*Config
+	QueryStats            *QueryStats
+	ConnStats             *ConnStats
+	BatchStats            *BatchStats
+	ModelStats            *ModelStats
 	refreshOnce, pushOnce sync.Once
+	exportOnce            sync.Once
+	saturation            *saturationSampler
+	reuse                 *connReuse
+	debug                 debugState
+	refreshFailures       uint32 // consecutive failed refreshes of DBStats
+	refreshSlots          chan struct{}
+	pushState             *pushState
+	pushStateOnce         sync.Once
+	migrationDuration     *prometheus.GaugeVec
+	migrationOnce         sync.Once
+	intervals             []prometheus.Collector // refresh and push interval gauges
+	health                *healthState
+	healthOnce            sync.Once
+	scrapes               prometheus.Counter
+	scrapesOnce           sync.Once
+	connOnce, batchOnce   sync.Once
+	modelLock             sync.Mutex
+	startLock             sync.Mutex
+	started, stopped      bool
+	pending               []func()      // background loops waiting for Start, with ManualStart
+	done                  chan struct{} // closed by Stop
+	running               sync.WaitGroup
+	shutdown              func()                 // shuts the http server down, set once it is started
+	paused                int32                  // 1 while paused, accessed atomically
+	refreshed             []prometheus.Collector // metrics of the Refreshers, marked stale by Pause
 	Labels                map[string]string
 	Collectors            []prometheus.Collector
 }
@@ -39,12 +90,238 @@ type Config struct {
 	DBName           string             // use DBName as metrics label
 	RefreshInterval  uint32             // refresh metrics interval.
 	PushAddr         string             // prometheus pusher address
+	PushAddrs        []string           // fallback pusher addresses, tried in order when the push to PushAddr fails
 	PushUser         string             // prometheus pusher basic auth user
 	PushPassword     string             // prometheus pusher basic auth password
 	StartServer      bool               // if true, create http server to expose metrics
 	HTTPServerPort   uint32             // http server port
+	DebugPath        string             // if set, e.g. "/debug/gorm", the http server also dumps the plugin state (refreshes, last errors) as text
+	JSONPath         string             // if set, e.g. "/metrics.json", the http server also exposes the metrics as JSON on this path, stale values as null
 	MetricsCollector []MetricsCollector // collector
 	Labels           map[string]string  // metrics labels
+	CallbackMetrics  bool               // if true, register gorm callbacks to collect query metrics
+	Operations       []string           // operations instrumented by CallbackMetrics: create, query, update, delete, row and raw, default all, others fail Initialize
+	SQLDuration      bool               // if true, also record the time spent in SQL apart from hooks, requires CallbackMetrics
+	DurationBuckets  []float64          // buckets of the duration histograms, default 0.1ms, 0.25ms ... 15s
+	RowsMetrics      bool               // if true, record the rows returned or affected per operation, requires CallbackMetrics
+	RowsBuckets      []float64          // buckets of the rows histogram, default 1, 4, 16 ... 16384
+	RowsByTable      bool               // if true, label the rows histogram by table, at most MaxTables tables
+	MetricHelp       map[string]string  // override the help text of metrics, keyed by metric name
+	PoolLabel        bool               // if true, label query metrics by the dbresolver pool used, "source" or "replica"
+	CollectorLabel   bool               // if true, label the metrics of Named MetricsCollectors with collector, e.g. "mysql"
+	TableLabels      []interface{}      // tables labeled in query metrics, table names or models, other tables are aggregated as "other"
+	DriverMetrics    bool               // if true, Connector and Driver also count and time the statements, of gorm and raw database/sql calls
+	IdleRatio        bool               // if true, expose Idle / OpenConnections as gorm_dbstats_idle_ratio, 1 while no connection is open
+	Info             map[string]string  // metadata exposed as the labels of gorm_prometheus_info, e.g. team, owner or tier, fixed per instance
+
+	// MinDuration skips the duration observations of operations faster than it, e.g. time.Millisecond, to save the
+	// histogram overhead of trivial queries at high QPS. The histograms then only describe the slower operations,
+	// their quantiles and averages are biased upwards, the counters still count every operation. Default all
+	MinDuration time.Duration
+
+	// DBNameFunc derives the db_name label of the query metrics per statement, e.g. from the schema of a shard,
+	// the static DBName still labels the other metrics. Each distinct name adds a series per operation and label,
+	// so it must return a small, bounded set of names, "" is DBName. SchemaDBName labels the active schema
+	DBNameFunc func(*gorm.DB) string
+
+	// StatementLabel labels query metrics by the verb of the SQL executed, e.g. select for a Raw query, parsed
+	// from the statement SQL, verbs other than select, insert, update, delete, replace, call and show are "other"
+	StatementLabel bool
+
+	// OriginFromContext labels query metrics by origin, the logical caller carried in the statement context,
+	// e.g. the HTTP route or job name, never an ID. At most MaxOrigins distinct origins (default 100) are
+	// labeled, later ones are "other", and statements without origin are "unknown"
+	OriginFromContext func(context.Context) string
+	MaxOrigins        int
+
+	// MaxTables bounds the tables labeling the rows histogram with RowsByTable, e.g. with sharded tables named by
+	// date. At most MaxTables distinct tables (default 100) are labeled, later ones and raw SQL without table are
+	// "other". With TableLabels, only these tables are labeled
+	MaxTables int
+
+	// CallerSampleRate records the duration of this fraction of the operations, e.g. 0.001, in
+	// gorm_query_caller_duration_seconds labeled by caller, the file and line of the application code calling gorm,
+	// e.g. "orders/service.go:42", to find the code paths issuing slow queries. Walking the stack of a sampled
+	// operation costs a few microseconds and each caller adds a histogram per operation, so keep the rate low. At
+	// most MaxCallers distinct callers (default 50) are labeled, later ones are "other". Default 0, requires
+	// CallbackMetrics
+	CallerSampleRate float64
+	MaxCallers       int
+
+	// MaxLabelSets caps the distinct label sets of each query metric, e.g. with table, origin or db_name labels,
+	// later label sets are collapsed into one with every label "overflow" and counted in
+	// gorm_label_sets_overflow_total. LabelSetLimits overrides the cap by metric name without the gorm_ prefix,
+	// e.g. {"query_duration_seconds": 500}, default unlimited
+	MaxLabelSets   int
+	LabelSetLimits map[string]int
+
+	// AssociationLabels are the associations labeled in the preload and join metrics, e.g. "Orders" or
+	// "Orders.Items" for nested preloads, other associations and raw joins are aggregated as "other"
+	AssociationLabels []string
+
+	// TransactionMetrics counts the savepoints of nested transactions by outcome, created, released or rolled_back,
+	// requires CallbackMetrics
+	TransactionMetrics bool
+
+	// ExecutionLabel labels query metrics by execution, "cached" for a statement of the gorm PrepareStmt cache,
+	// "prepared" when the statement was prepared and cached by the query, "direct" otherwise. It's detected from
+	// the gorm cache only, statements prepared by the driver, e.g. the pgx statement cache, are "direct"
+	ExecutionLabel bool
+
+	// ExplainSampleRate runs EXPLAIN in the background for this fraction of the queries, e.g. 0.001, on Postgres and
+	// MySQL, and records the estimated cost of their plan in gorm_query_plan_cost. At most one EXPLAIN runs at a
+	// time and queries in transactions aren't sampled, but each EXPLAIN is a round trip, keep the rate low. Default 0
+	ExplainSampleRate float64
+
+	// SlowQueryMetrics counts the operations slower than the SlowThreshold of the GORM logger in
+	// gorm_slow_queries_total, i.e. the slow queries the logger reports at the warn level, without configuring a
+	// second threshold, requires CallbackMetrics. The threshold is read from db.Logger when the plugin is
+	// initialized, the logger must be created by logger.New, the log level doesn't matter
+	SlowQueryMetrics bool
+
+	// PreparedStmtMetrics counts prepared statement cache hits and misses, and exposes the statements cached by table
+	// to pinpoint a growing cache, e.g. queries with IN lists of varying length. Requires CallbackMetrics, not
+	// registered unless the DB is opened with PrepareStmt
+	PreparedStmtMetrics bool
+
+	// DurationSummary records the query duration as a summary with SummaryObjectives (default p50, p95 and p99)
+	// instead of a histogram. Summaries need no bucket tuning and give accurate quantiles, but their quantiles
+	// can't be aggregated across instances, prefer the histogram unless there is a single instance
+	DurationSummary   bool
+	SummaryObjectives map[float64]float64
+
+	// ExemplarFromContext returns exemplar labels (e.g. a trace ID) for the query duration, exemplars are only
+	// exposed when the scrape negotiates the OpenMetrics format
+	ExemplarFromContext func(context.Context) prometheus.Labels
+
+	// SaturationSampleInterval enables gorm_dbstats_saturation_ratio, the fraction of pool samples taken every
+	// SaturationSampleInterval where all MaxOpenConnections were in use, exposed every RefreshInterval, and
+	// gorm_dbstats_no_idle_seconds, the time since the pool last had an idle connection.
+	// It must be well below RefreshInterval, e.g. 100 * time.Millisecond, unsampled by default
+	SaturationSampleInterval time.Duration
+
+	// ConnReuseRatio enables gorm_dbstats_conn_reuse_ratio, the estimated fraction of the operations since the last
+	// refresh served by a connection already open rather than a new one, a low ratio indicates pool thrash, e.g.
+	// MaxIdleConns too low for the load. Requires CallbackMetrics, see Connector for an exact count of the
+	// connections opened
+	ConnReuseRatio bool
+
+	// RatioWindow is the window of gorm_dbstats_saturation_ratio and gorm_dbstats_conn_reuse_ratio, rounded up to
+	// whole RefreshIntervals, e.g. 5 * time.Minute to smooth the ratios over 20 refreshes of 15 seconds. The ratios
+	// are still exposed every RefreshInterval, each refresh moves the window by one interval. Default one interval
+	RatioWindow time.Duration
+
+	// Exporter pushes the metrics every ExportInterval seconds (default RefreshInterval) in addition to or instead
+	// of the Pushgateway, e.g. to an OTLP collector
+	Exporter       Exporter
+	ExportInterval uint32
+
+	// PushHeader and PushUserAgent are added to the push requests, e.g. for a proxy identifying clients by header
+	PushHeader    http.Header
+	PushUserAgent string
+
+	// PushTimestamps sets the timestamp of the pushed metrics to the time of the last refresh instead of leaving
+	// it to the time Prometheus scrapes the Pushgateway, for long push intervals. Recent Pushgateway versions
+	// reject pushes with timestamps, use it with receivers of the push protocol accepting them, e.g. VictoriaMetrics,
+	// and scrape them with honor_timestamps
+	PushTimestamps bool
+
+	// PushGatherer is pushed, and exported by Exporter, instead of the plugin metrics, to push another set than the
+	// pull endpoint exposes. E.g. to also push expensive MetricsCollectors not pulled, register the plugin metrics in
+	// a push registry with Registerers and the collectors there only with their Registerer, or the reverse for
+	// collectors only pulled. Default the plugin metrics, the same as pulled
+	PushGatherer prometheus.Gatherer
+
+	// Instance labels every metric with instance and groups pushes by instance, so series of several instances
+	// don't collide, the pushed metrics carry it in the grouping key only, as the Pushgateway requires. With
+	// InstanceLabel and no Instance, the hostname is used. PushInstance groups the pushes by instance, the hostname
+	// if Instance isn't set, without labeling the metrics, or the "instance" of Labels if set
+	Instance      string
+	InstanceLabel bool
+	PushInstance  bool
+
+	// StatusDB runs the queries of the status collectors, e.g. SHOW STATUS, instead of the application pool, so
+	// scrapes don't take application connections and can use a read-only monitoring user
+	StatusDB *sql.DB
+
+	// MaxConcurrentRefreshes bounds the number of MetricsCollectors refreshing at the same time, each one is
+	// refreshed on its own schedule, default unbounded. RefreshTimeout reports a refresh taking longer as failed,
+	// the refresh isn't cancelled and the collector skips its next refreshes until it returns, default no timeout
+	MaxConcurrentRefreshes int
+	RefreshTimeout         time.Duration
+
+	// StaleAfter marks the gauges of DBStats and of a refreshed MetricsCollector stale after this many consecutive
+	// failed refreshes, gauges are set to NaN and gauge vecs stop exposing series until a refresh succeeds,
+	// default 0 keeps the last values
+	StaleAfter uint32
+
+	// Registry registers and exposes the plugin metrics instead of the global registry, e.g. to keep them apart
+	// from the Go and process metrics
+	Registry *prometheus.Registry
+
+	// Registerers also register the plugin metrics, e.g. prometheus.DefaultRegisterer while migrating to Registry,
+	// the plugin endpoints keep exposing Registry. A metric already registered in one is still registered in the
+	// others. The registerers of MetricsCollectors with their own Registerer aren't affected
+	Registerers []prometheus.Registerer
+
+	// RuntimeMetrics registers the Go runtime and process collectors in Registry, so a single endpoint exposes
+	// them with the DB metrics, the global registry already has them
+	RuntimeMetrics bool
+
+	// Gatherers are additional paths served by the http server, e.g. {"/metrics/status": registry} with registry
+	// the Registerer of heavy MetricsCollectors, so they can be scraped less often than the pool stats
+	Gatherers map[string]prometheus.Gatherer
+
+	// HandlerOpts configures the metrics handler, e.g. Timeout, MaxRequestsInFlight, ErrorHandling and DisableCompression,
+	// defaults to a 10 seconds timeout and at most 10 concurrent scrapes. It's promhttp.HandlerOpts, an empty struct
+	// with the gorm_prometheus_noserver build tag, which doesn't import promhttp
+	HandlerOpts *HandlerOpts
+
+	// OnScrape is called after each scrape of the metrics handlers with the remote address and the time spent
+	// serving it, e.g. to log scrapes, gorm_prometheus_scrape_requests_total counts them regardless
+	OnScrape func(remoteAddr string, duration time.Duration)
+
+	// AfterRefresh is called after each successful refresh of the pool stats, every RefreshInterval, e.g. to update
+	// derived metrics. The MetricsCollectors are refreshed independently, on their own interval. It runs on the
+	// refresh goroutine, a panic is recovered and logged
+	AfterRefresh func()
+
+	// TLSConfig serves the metrics over HTTPS with the certificates of TLSConfig, plain HTTP by default. For mutual
+	// TLS, the scrapers must present a certificate signed by ClientCAs:
+	//
+	//	TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}, ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	TLSConfig *tls.Config
+
+	// Middleware wraps the handlers of the http server, e.g. for authentication or IP allowlisting, the first
+	// middleware is the outermost
+	Middleware []func(http.Handler) http.Handler
+
+	// ServerErrorLog logs the http server and metrics handler errors, e.g. broken scrapes, default the GORM logger
+	ServerErrorLog *log.Logger
+
+	// LabelTransformer transforms the labels of every metric before they're attached, e.g. to lowercase or prefix
+	// label names, it's given the constant labels and the variable label names (with empty values), default identity
+	LabelTransformer func(map[string]string) map[string]string
+
+	// MetricNamer builds the name of every metric from its component, e.g. "dbstats", "status" or "" for query and
+	// connection metrics, and its name within the component, default DefaultMetricNamer. The Prefix of a
+	// MetricsCollector still takes precedence for its metrics when set
+	MetricNamer func(component, name string) string
+
+	// Preset names the metrics to match ready-made dashboards, e.g. PresetSQLStats, default the native names
+	Preset string
+
+	// Enabled is consulted before each refresh and push, when it returns false they're skipped and the metrics keep
+	// their last values, e.g. to disable collection with a feature flag without redeploying, default always enabled
+	Enabled func() bool
+
+	// DBStatsGauges exposes the cumulative DBStats, e.g. wait_count and max_idle_closed, as gauges named without the
+	// _total suffix of their counters, as before they were counters, to keep existing dashboards and alerts working
+	DBStatsGauges bool
+
+	// ManualStart makes Initialize only register the metrics and callbacks, the refreshes, http server, pushes and
+	// exports start when Start is called, e.g. to control the lifecycle in tests, default started by Initialize
+	ManualStart bool
 }
 
 func New(config Config) *Prometheus {
@@ -56,12 +333,122 @@ func New(config Config) *Prometheus {
 		config.HTTPServerPort = defaultHTTPServerPort
 	}
 
+	if config.HandlerOpts == nil {
+		config.HandlerOpts = defaultHandlerOpts()
+	}
+
+	config.applyPreset()
+
 	labels := make(map[string]string)
 	if config.Labels != nil {
 		labels = config.Labels
 	}
 
-	return &Prometheus{Config: &config, Labels: labels}
+	if config.DBName != "" {
+		labels["db_name"] = config.DBName // metrics created before Initialize, e.g. by Connector, need it too
+	}
+
+	if config.InstanceLabel && config.Instance == "" {
+		config.Instance, _ = os.Hostname()
+	}
+
+	if config.Instance != "" {
+		labels["instance"] = config.Instance
+	}
+
+	return &Prometheus{Config: &config, Labels: config.transformLabels(labels)}
+}
+
+// transformLabels applies the LabelTransformer to the labels
+func (c *Config) transformLabels(labels map[string]string) map[string]string {
+	if c.LabelTransformer == nil {
+		return labels
+	}
+	return c.LabelTransformer(labels)
+}
+
+// transformLabelNames applies the LabelTransformer to variable label names, returned sorted
+func (c *Config) transformLabelNames(names []string) []string {
+	if c.LabelTransformer == nil {
+		return names
+	}
+
+	labels := make(map[string]string, len(names))
+	for _, name := range names {
+		labels[name] = ""
+	}
+
+	names = make([]string, 0, len(labels))
+	for name := range c.LabelTransformer(labels) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultMetricNamer names metrics gorm_<component>_<name>, or gorm_<name> without component
+func DefaultMetricNamer(component, name string) string {
+	if component == "" {
+		return "gorm_" + name
+	}
+	return "gorm_" + component + "_" + name
+}
+
+// metricName returns the name of the metric built by the MetricNamer
+func (c *Config) metricName(component, name string) string {
+	if c.MetricNamer == nil {
+		return DefaultMetricNamer(component, name)
+	}
+	return c.MetricNamer(component, name)
+}
+
+// statusName returns the name of a MetricsCollector metric, prefixed by prefix if set, by default gorm_status_<name>
+func (c *Config) statusName(prefix, name string) string {
+	if prefix != "" {
+		return prefix + name
+	}
+	return c.metricName("status", name)
+}
+
+// collectorLabels returns the constant labels of the metrics of mc, with its collector label if CollectorLabel
+func (p *Prometheus) collectorLabels(mc MetricsCollector) map[string]string {
+	named, ok := mc.(Named)
+	if !p.Config.CollectorLabel || !ok {
+		return p.Labels
+	}
+
+	labels := make(map[string]string, len(p.Labels)+1)
+	for k, v := range p.Labels {
+		labels[k] = v
+	}
+	for k, v := range p.transformLabels(map[string]string{"collector": named.Name()}) {
+		labels[k] = v
+	}
+	return labels
+}
+
+// maxOrigins returns MaxOrigins, or the default
+func (c *Config) maxOrigins() int {
+	if c.MaxOrigins > 0 {
+		return c.MaxOrigins
+	}
+	return defaultMaxOrigins
+}
+
+// maxTables returns MaxTables, or the default
+func (c *Config) maxTables() int {
+	if c.MaxTables > 0 {
+		return c.MaxTables
+	}
+	return defaultMaxTables
+}
+
+// help returns the configured help text of the metric, or the given default
+func (c *Config) help(name, help string) string {
+	if h, ok := c.MetricHelp[name]; ok {
+		return h
+	}
+	return help
 }
 
 func (p *Prometheus) Name() string {
@@ -69,79 +456,605 @@ func (p *Prometheus) Name() string {
 }
 
 func (p *Prometheus) Initialize(db *gorm.DB) error { // can be called repeatedly
+	if p.Config.CallbackMetrics {
+		if err := unknownOperations(db, p.Config.Operations); err != nil {
+			return err
+		}
+	}
+
 	p.DB = db
 
-	if p.Config.DBName != "" {
-		p.Labels["db_name"] = p.Config.DBName
-	}
+	p.DBStats = newStats(p.Labels, p.Config)
+	p.register(p.DBStats.Collectors()...)
 
-	p.DBStats = newStats(p.Labels)
+	p.healthOnce.Do(func() {
+		p.health = newHealthState(p.Labels, p.Config)
+		p.register(p.health.Collectors()...)
+	})
+
+	if p.Config.CallbackMetrics {
+		if p.QueryStats == nil {
+			p.QueryStats = newQueryStats(p.Labels, p.Config, db.PrepareStmt)
+			p.register(p.QueryStats.Collectors()...)
+		}
+
+		if p.Config.ConnReuseRatio && p.reuse == nil {
+			p.reuse = newConnReuse(p.Labels, p.Config)
+			p.QueryStats.reuse = p.reuse
+			p.register(p.reuse.ratio)
+		}
+
+		if err := p.QueryStats.registerCallbacks(db); err != nil {
+			return err
+		}
+		p.health.callbacksRegistered(p.Config, p.QueryStats.operations)
+	}
 
 	p.refreshOnce.Do(func() {
-		for _, mc := range p.MetricsCollector {
-			p.Collectors = append(p.Collectors, mc.Metrics(p)...)
+		if p.Config.MaxConcurrentRefreshes > 0 {
+			p.refreshSlots = make(chan struct{}, p.Config.MaxConcurrentRefreshes)
 		}
 
-		go func() {
-			for range time.Tick(time.Duration(p.Config.RefreshInterval) * time.Second) {
-				p.refresh()
+		for _, mc := range p.MetricsCollector {
+			collectors := p.safeMetrics(mc)
+			p.Collectors = append(p.Collectors, collectors...)
+
+			if r, ok := mc.(Refresher); ok {
+				p.refreshed = append(p.refreshed, collectors...)
+				interval := r.RefreshInterval()
+				if interval == 0 {
+					interval = p.Config.RefreshInterval
+				}
+
+				var failures uint32
+				var running int32
+				p.every(interval, func() {
+					if !atomic.CompareAndSwapInt32(&running, 0, 1) {
+						return // the previous refresh exceeded RefreshTimeout and is still running
+					}
+
+					err := p.refreshCollector(r, &running)
+					p.health.refreshed(p.Config, r, err)
+					if err != nil { // collectors log their errors
+						p.recordError(fmt.Sprintf("%T", r), err)
+						if failures++; failures == p.Config.StaleAfter {
+							markStale(collectors)
+						}
+					} else {
+						failures = 0
+					}
+				})
 			}
-		}()
+		}
+
+		if p.Config.RuntimeMetrics && p.Config.Registry != nil {
+			p.register(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+		}
+
+		if p.Config.SaturationSampleInterval > 0 {
+			p.saturation = newSaturationSampler(p.Labels, p.Config)
+			p.register(p.saturation.ratio, p.saturation.noIdle)
+			p.startSaturationSampler()
+		}
+
+		p.registerIntervals()
+		p.every(p.Config.RefreshInterval, p.refresh)
 	})
 
 	if p.Config.StartServer {
 		httpServerOnce.Do(func() { // only start once
-			go p.startServer()
+			p.background(p.startServer)
 		})
 	}
 
 	if p.PushAddr != "" {
 		p.pushOnce.Do(func() {
-			go p.startPush()
+			p.background(p.startPush)
 		})
 	}
 
+	if p.Config.Exporter != nil {
+		p.exportOnce.Do(p.startExport)
+	}
+
 	return nil
 }
 
+// register registers the collectors, collectors already registered are ignored
+func (p *Prometheus) register(collectors ...prometheus.Collector) {
+	for _, collector := range collectors {
+		_ = p.registerer(nil).Register(collector)
+	}
+}
+
+// registerer returns the registerer of a MetricsCollector, the plugin registerer if nil
+func (p *Prometheus) registerer(registerer prometheus.Registerer) prometheus.Registerer {
+	if registerer != nil {
+		return registerer
+	}
+
+	registerer = prometheus.DefaultRegisterer
+	if p.Config.Registry != nil {
+		registerer = p.Config.Registry
+	}
+	if len(p.Config.Registerers) == 0 {
+		return registerer
+	}
+
+	registerers := multiRegisterer{registerer}
+	for _, r := range p.Config.Registerers {
+		if r != nil && r != registerer {
+			registerers = append(registerers, r)
+		}
+	}
+	return registerers
+}
+
+// multiRegisterer registers the collectors in every registerer, independently of each other
+type multiRegisterer []prometheus.Registerer
+
+// Register returns the first error, e.g. AlreadyRegisteredError, the collector is registered in the other
+// registerers anyway
+func (registerers multiRegisterer) Register(collector prometheus.Collector) error {
+	var firstErr error
+	for _, registerer := range registerers {
+		if err := registerer.Register(collector); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (registerers multiRegisterer) MustRegister(collectors ...prometheus.Collector) {
+	for _, collector := range collectors {
+		if err := registerers.Register(collector); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (registerers multiRegisterer) Unregister(collector prometheus.Collector) bool {
+	unregistered := false
+	for _, registerer := range registerers {
+		if registerer.Unregister(collector) {
+			unregistered = true
+		}
+	}
+	return unregistered
+}
+
+// Gatherer returns the gatherer of the plugin metrics, e.g. to expose them with the metrics of the application
+// on a single endpoint scraped by a federating agent:
+//
+//	handler := promhttp.HandlerFor(prometheus.Gatherers{appRegistry, p.Gatherer()}, promhttp.HandlerOpts{})
+//
+// Unless Config.Registry is set it's the global gatherer, which must not be combined with itself.
+func (p *Prometheus) Gatherer() prometheus.Gatherer {
+	if p.Config.Registry != nil {
+		return p.Config.Registry
+	}
+	return prometheus.DefaultGatherer
+}
+
+// Registry returns the registry of the plugin metrics, to register other collectors in the same exposition,
+// the global registry unless Config.Registry is set. It's nil if the global registerer was replaced by
+// something else than a registry
+func (p *Prometheus) Registry() *prometheus.Registry {
+	if p.Config.Registry != nil {
+		return p.Config.Registry
+	}
+	registry, _ := prometheus.DefaultRegisterer.(*prometheus.Registry)
+	return registry
+}
+
+// collectors returns the collectors of the plugin and of its MetricsCollectors
+func (p *Prometheus) collectors() []prometheus.Collector {
+	var collectors []prometheus.Collector
+	if p.DBStats != nil {
+		collectors = append(collectors, p.DBStats.Collectors()...)
+	}
+	if p.QueryStats != nil {
+		collectors = append(collectors, p.QueryStats.Collectors()...)
+	}
+	if p.ConnStats != nil {
+		collectors = append(collectors, p.ConnStats.Collectors()...)
+	}
+	if p.BatchStats != nil {
+		collectors = append(collectors, p.BatchStats.Collectors()...)
+	}
+	if p.ModelStats != nil {
+		collectors = append(collectors, p.ModelStats.Collectors()...)
+	}
+	if p.saturation != nil {
+		collectors = append(collectors, p.saturation.ratio, p.saturation.noIdle)
+	}
+	if p.reuse != nil {
+		collectors = append(collectors, p.reuse.ratio)
+	}
+	if p.migrationDuration != nil {
+		collectors = append(collectors, p.migrationDuration)
+	}
+	if p.health != nil {
+		collectors = append(collectors, p.health.Collectors()...)
+	}
+	collectors = append(collectors, p.intervals...)
+	return append(collectors, p.Collectors...)
+}
+
+// ResetForTest unregisters the metrics of the plugin and of its MetricsCollectors from the plugin registry, so
+// tests can create the plugin again in the same process without AlreadyRegisteredError. It's meant for tests
+// only, the background refresh of the plugin isn't stopped and keeps updating the unregistered metrics.
+func (p *Prometheus) ResetForTest() {
+	registerer := p.registerer(nil)
+	for _, collector := range p.collectors() {
+		registerer.Unregister(collector)
+	}
+}
+
+// Descriptors returns the descriptors of the metrics exposed by the plugin, e.g. to lint metric names in CI.
+// It doesn't start any background work and can be called before the plugin is used by a DB:
+//
+//	descs := prometheus.New(config).Descriptors()
+//
+// Metrics of MetricsCollectors depend on the database, they are only included once the plugin is initialized,
+// the metrics of the plugin are built from the Config until then.
+func (p *Prometheus) Descriptors() []*prometheus.Desc {
+	collectors := p.collectors()
+	if p.DBStats == nil {
+		collectors = append(collectors, newStats(p.Labels, p.Config).Collectors()...)
+	}
+	if p.QueryStats == nil && p.Config.CallbackMetrics {
+		collectors = append(collectors, newQueryStats(p.Labels, p.Config, true).Collectors()...)
+	}
+	if p.reuse == nil && p.Config.CallbackMetrics && p.Config.ConnReuseRatio {
+		collectors = append(collectors, newConnReuse(p.Labels, p.Config).ratio)
+	}
+	if p.saturation == nil && p.Config.SaturationSampleInterval > 0 {
+		saturation := newSaturationSampler(p.Labels, p.Config)
+		collectors = append(collectors, saturation.ratio, saturation.noIdle)
+	}
+	if p.health == nil {
+		collectors = append(collectors, newHealthState(p.Labels, p.Config).Collectors()...)
+	}
+	if p.intervals == nil {
+		collectors = append(collectors, newIntervals(p.Labels, p.Config)...)
+	}
+
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		for _, collector := range collectors {
+			collector.Describe(ch)
+		}
+		close(ch)
+	}()
+
+	var descs []*prometheus.Desc
+	for desc := range ch {
+		descs = append(descs, desc)
+	}
+	return descs
+}
+
+// enabled reports whether metrics should be collected, see Config.Enabled and Pause
+func (p *Prometheus) enabled() bool {
+	return atomic.LoadInt32(&p.paused) == 0 && (p.Config.Enabled == nil || p.Config.Enabled())
+}
+
+// Pause skips the refreshes and pushes until Resume, e.g. while the DB is offline for maintenance, a refresh in
+// progress completes. The metrics keep their last values, with StaleAfter the gauges refreshed by the plugin are
+// marked stale instead. The query metrics of the callbacks are still recorded
+func (p *Prometheus) Pause() {
+	if !atomic.CompareAndSwapInt32(&p.paused, 0, 1) || p.Config.StaleAfter == 0 {
+		return
+	}
+
+	if p.DBStats != nil {
+		markStale(p.DBStats.Collectors())
+	}
+	markStale(p.refreshed)
+}
+
+// Resume resumes the refreshes and pushes paused by Pause, the metrics are refreshed on the next tick
+func (p *Prometheus) Resume() {
+	atomic.StoreInt32(&p.paused, 0)
+}
+
+// statusDB returns the DB of the status collector queries, on StatusDB if set
+func (p *Prometheus) statusDB() *gorm.DB {
+	if p.Config.StatusDB == nil {
+		return p.DB
+	}
+
+	tx := p.DB.Session(&gorm.Session{NewDB: true})
+	tx.Statement.ConnPool = p.Config.StatusDB
+	return tx
+}
+
+// statusSQLDB returns the sql.DB of the status collector queries, StatusDB if set
+func (p *Prometheus) statusSQLDB() (*sql.DB, error) {
+	if p.Config.StatusDB != nil {
+		return p.Config.StatusDB, nil
+	}
+	return p.DB.DB()
+}
+
+// every calls fn every interval seconds in a new goroutine, while enabled
+func (p *Prometheus) every(interval uint32, fn func()) {
+	p.loop(time.Duration(interval)*time.Second, func() {
+		if p.enabled() {
+			fn()
+		}
+	})
+}
+
+// loop runs fn every interval in the background until Stop
+func (p *Prometheus) loop(interval time.Duration, fn func()) {
+	p.background(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		done := p.stopping()
+		for {
+			select {
+			case <-ticker.C:
+				fn()
+			case <-done:
+				return
+			}
+		}
+	})
+}
+
+// background runs fn in a goroutine, with ManualStart once Start is called, Stop waits for it to return
+func (p *Prometheus) background(fn func()) {
+	p.startLock.Lock()
+	defer p.startLock.Unlock()
+
+	switch {
+	case p.stopped:
+		return
+	case p.Config.ManualStart && !p.started:
+		p.pending = append(p.pending, fn)
+		return
+	}
+	p.run(fn)
+}
+
+// run runs fn in a goroutine tracked for Stop, the caller holds startLock
+func (p *Prometheus) run(fn func()) {
+	p.running.Add(1)
+	go func() {
+		defer p.running.Done()
+		fn()
+	}()
+}
+
+// stopping returns the channel closed by Stop
+func (p *Prometheus) stopping() <-chan struct{} {
+	p.startLock.Lock()
+	defer p.startLock.Unlock()
+
+	if p.done == nil {
+		p.done = make(chan struct{})
+	}
+	return p.done
+}
+
+// Start starts the refreshes, http server, pushes and exports wired up by Initialize with ManualStart, it does
+// nothing otherwise or when called again:
+//
+//	p := prometheus.New(prometheus.Config{DBName: "db1", StartServer: true, ManualStart: true})
+//	db.Use(p)
+//	p.Start()
+func (p *Prometheus) Start() {
+	p.startLock.Lock()
+	defer p.startLock.Unlock()
+
+	if p.started || p.stopped {
+		return
+	}
+	p.started = true
+
+	for _, fn := range p.pending {
+		p.run(fn)
+	}
+	p.pending = nil
+}
+
+// Stop stops the refreshes, http server, pushes and exports, and returns once they have returned, a refresh in
+// progress is completed first. The MetricsCollectors implementing io.Closer are closed then, e.g. to release the
+// connection of MySQL SessionSetup. The metrics keep their last values, the plugin can't be started again:
+//
+//	p.Stop()
+//	value := testutil.ToFloat64(p.DBStats.InUse) // no refresh runs concurrently anymore
+func (p *Prometheus) Stop() {
+	p.startLock.Lock()
+	if p.stopped {
+		p.startLock.Unlock()
+		p.running.Wait()
+		return
+	}
+	p.stopped = true
+	p.pending = nil
+	if p.done == nil {
+		p.done = make(chan struct{})
+	}
+	close(p.done)
+	shutdown := p.shutdown
+	p.startLock.Unlock()
+
+	if shutdown != nil {
+		shutdown()
+	}
+	p.running.Wait()
+
+	for _, mc := range p.MetricsCollector {
+		if closer, ok := mc.(io.Closer); ok {
+			if err := closer.Close(); err != nil && p.DB != nil {
+				p.DB.Logger.Error(context.Background(), "gorm:prometheus failed to close %T, got error: %v", mc, err)
+			}
+		}
+	}
+}
+
+// registerIntervals exposes the refresh interval, and the push interval when pushing, to correlate the resolution
+// of the metrics of instances with different configs
+func (p *Prometheus) registerIntervals() {
+	p.intervals = newIntervals(p.Labels, p.Config)
+	p.register(p.intervals...)
+}
+
+func newIntervals(labels map[string]string, config *Config) []prometheus.Collector {
+	refreshInterval := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        config.metricName("prometheus", "refresh_interval_seconds"),
+		Help:        config.help(config.metricName("prometheus", "refresh_interval_seconds"), "Configured interval of the DBStats refresh, in seconds."),
+		ConstLabels: labels,
+	})
+	refreshInterval.Set(float64(config.RefreshInterval))
+	intervals := []prometheus.Collector{refreshInterval}
+
+	if config.PushAddr != "" {
+		pushInterval := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        config.metricName("prometheus", "push_interval_seconds"),
+			Help:        config.help(config.metricName("prometheus", "push_interval_seconds"), "Configured interval of the pushes to the Pushgateway, in seconds."),
+			ConstLabels: labels,
+		})
+		pushInterval.Set(float64(config.RefreshInterval)) // pushed every refresh
+		intervals = append(intervals, pushInterval)
+	}
+	return intervals
+}
+
 func (p *Prometheus) refresh() {
 	if db, err := p.DB.DB(); err == nil {
-		p.DBStats.Set(db.Stats())
+		stats := db.Stats()
+		p.DBStats.Set(stats)
+		if p.saturation != nil {
+			p.saturation.refresh()
+		}
+		if p.reuse != nil {
+			p.reuse.refresh(stats, p.openedCounter())
+		}
+		if p.QueryStats != nil && p.QueryStats.PreparedStmts != nil {
+			if cache := preparedStmtDB(p.DB.ConnPool); cache != nil {
+				p.QueryStats.refreshPreparedStmts(cache)
+			}
+		}
+		p.recordRefresh()
+		p.refreshFailures = 0
+		p.afterRefresh()
 	} else {
 		p.DB.Logger.Error(context.Background(), "gorm:prometheus failed to collect db status, got error: %v", err)
+		p.recordError("dbstats", err)
+		if p.refreshFailures++; p.refreshFailures == p.Config.StaleAfter {
+			markStale(p.DBStats.Collectors())
+		}
 	}
 }
 
-func (p *Prometheus) startPush() {
-	pusher := push.New(p.PushAddr, p.DBName)
-
-	if p.PushUser != "" || p.PushPassword != "" {
-		pusher.BasicAuth(p.PushUser, p.PushPassword)
+// openedCounter returns the count of the connections opened by Connector, nil if it isn't used
+func (p *Prometheus) openedCounter() prometheus.Counter {
+	if p.ConnStats == nil {
+		return nil
 	}
+	return p.ConnStats.Opened
+}
 
-	for _, collector := range p.DBStats.Collectors() {
-		pusher = pusher.Collector(collector)
+// afterRefresh calls AfterRefresh, recovering from a panic
+func (p *Prometheus) afterRefresh() {
+	if p.Config.AfterRefresh == nil {
+		return
 	}
 
-	for _, c := range p.Collectors {
-		pusher = pusher.Collector(c)
+	defer func() {
+		if v := recover(); v != nil {
+			p.DB.Logger.Error(context.Background(), "gorm:prometheus AfterRefresh panicked: %v", v)
+		}
+	}()
+	p.Config.AfterRefresh()
+}
+
+// refreshCollector refreshes r, waiting for a slot if MaxConcurrentRefreshes is set. A refresh can't be
+// cancelled, past RefreshTimeout it's reported as failed but keeps its slot and running set until it returns
+func (p *Prometheus) refreshCollector(r Refresher, running *int32) error {
+	if p.refreshSlots != nil {
+		p.refreshSlots <- struct{}{}
 	}
 
-	for range time.Tick(time.Duration(p.Config.RefreshInterval) * time.Second) {
-		err := pusher.Push()
-		if err != nil {
-			p.DB.Logger.Error(context.Background(), "gorm:prometheus push err: ", err)
+	done := make(chan error, 1)
+	p.running.Add(1) // a refresh outliving RefreshTimeout still completes before Stop returns
+	go func() {
+		defer p.running.Done()
+		defer atomic.StoreInt32(running, 0)
+		if p.refreshSlots != nil {
+			defer func() { <-p.refreshSlots }()
 		}
+
+		done <- p.safeRefresh(r)
+	}()
+
+	if p.Config.RefreshTimeout == 0 {
+		return <-done
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(p.Config.RefreshTimeout):
+		err := fmt.Errorf("refresh of %T exceeded %s", r, p.Config.RefreshTimeout)
+		p.DB.Logger.Error(context.Background(), "gorm:prometheus %v", err)
+		return err
 	}
 }
 
-var httpServerOnce sync.Once
+// safeRefresh refreshes r, a panic is recovered and returned as the error of the refresh so a broken collector
+// is reported down instead of crashing the application
+func (p *Prometheus) safeRefresh(r Refresher) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("refresh of %T panicked: %v", r, v)
+			p.DB.Logger.Error(context.Background(), "gorm:prometheus %v", err)
+		}
+	}()
+
+	return r.Refresh(p)
+}
+
+// safeMetrics returns the metrics of mc, none if it panics, the collector is then reported down. It is also reported
+// down when the collect done by Metrics fails, until its next refresh
+func (p *Prometheus) safeMetrics(mc MetricsCollector) (collectors []prometheus.Collector) {
+	defer func() {
+		if v := recover(); v != nil {
+			err := fmt.Errorf("metrics of %T panicked: %v", mc, v)
+			p.DB.Logger.Error(context.Background(), "gorm:prometheus %v", err)
+			p.health.refreshed(p.Config, mc, err)
+			collectors = nil
+		}
+	}()
+
+	collectors = mc.Metrics(p)
+	if r, ok := mc.(interface{ metricsError() error }); ok {
+		err := r.metricsError()
+		p.health.refreshed(p.Config, mc, err)
+		if err != nil { // collectors log their errors
+			p.recordError(fmt.Sprintf("%T", mc), err)
+		}
+	}
+	return collectors
+}
 
-func (p *Prometheus) startServer() {
-	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.Handler())
-	err := http.ListenAndServe(fmt.Sprintf(":%d", p.Config.HTTPServerPort), mux)
-	if err != nil {
-		p.DB.Logger.Error(context.Background(), "gorm:prometheus listen and serve err: ", err)
+// markStale sets the gauges to NaN and removes the series of gauge vecs until they're refreshed again, counters
+// keep their value
+func markStale(collectors []prometheus.Collector) {
+	for _, collector := range collectors {
+		switch c := collector.(type) {
+		case interface{ cumulative() }: // counters implementing prometheus.Gauge, e.g. the cumulative DBStats
+		case prometheus.Gauge:
+			c.Set(math.NaN())
+		case *prometheus.GaugeVec:
+			c.Reset()
+		}
 	}
 }
+
+var httpServerOnce sync.Once
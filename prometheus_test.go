@@ -0,0 +1,227 @@
+package prometheus
+
+import (
+	"database/sql"
+	"math"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newMockPrometheus returns a plugin whose DB is backed by sqlmock, without starting any background work
+func newMockPrometheus(t *testing.T) (*Prometheus, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock, got error: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	p := New(Config{})
+	p.DB = &gorm.DB{Config: &gorm.Config{Logger: logger.Discard, ConnPool: db}}
+	return p, mock
+}
+
+type panickingCollector struct{}
+
+func (panickingCollector) Metrics(*Prometheus) []prometheus.Collector { panic("metrics") }
+func (panickingCollector) RefreshInterval() uint32                    { return 0 }
+func (panickingCollector) Refresh(*Prometheus) error                  { panic("refresh") }
+
+func TestRefreshPanic(t *testing.T) {
+	p, _ := newMockPrometheus(t)
+	p.health = newHealthState(nil, p.Config)
+
+	if collectors := p.safeMetrics(panickingCollector{}); collectors != nil {
+		t.Errorf("expected no collectors, got %v", collectors)
+	}
+
+	running := int32(1)
+	if err := p.refreshCollector(panickingCollector{}, &running); err == nil || !strings.Contains(err.Error(), "panicked") {
+		t.Errorf("expected the panic as error, got %v", err)
+	}
+	p.running.Wait() // running is cleared once the refresh goroutine returns
+	if atomic.LoadInt32(&running) != 0 {
+		t.Errorf("expected the refresh to be done")
+	}
+}
+
+func TestRegisterers(t *testing.T) {
+	registry, legacy := prometheus.NewRegistry(), prometheus.NewRegistry()
+	p := New(Config{Registry: registry, Registerers: []prometheus.Registerer{legacy}})
+
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_registerers_total"})
+	_ = legacy.Register(counter)
+	if err := p.registerer(nil).Register(counter); err == nil {
+		t.Errorf("expected AlreadyRegisteredError from the legacy registry")
+	}
+
+	for _, r := range []*prometheus.Registry{registry, legacy} {
+		if n, err := testutil.GatherAndCount(r, "test_registerers_total"); err != nil || n != 1 {
+			t.Errorf("expected the counter in both registries, got %d, %v", n, err)
+		}
+	}
+}
+
+func TestPause(t *testing.T) {
+	p, _ := newMockPrometheus(t)
+	p.Config.StaleAfter = 1
+	p.DBStats = newStats(nil, p.Config)
+	p.DBStats.Set(sql.DBStats{OpenConnections: 3})
+
+	p.Pause()
+	if p.enabled() {
+		t.Errorf("expected the collection disabled while paused")
+	}
+	if v := testutil.ToFloat64(p.DBStats.OpenConnections); !math.IsNaN(v) {
+		t.Errorf("expected the gauges stale while paused, got %v", v)
+	}
+
+	p.Resume()
+	if !p.enabled() {
+		t.Errorf("expected the collection enabled once resumed")
+	}
+}
+
+func TestManualStart(t *testing.T) {
+	_, _, p := newMockDB(t, Config{CallbackMetrics: true})
+	if len(p.pending) == 0 {
+		t.Fatalf("expected the refresh waiting for Start")
+	}
+
+	ran := make(chan struct{})
+	p.background(func() { close(ran) })
+	select {
+	case <-ran:
+		t.Fatalf("expected nothing started before Start")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	p.Start()
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the background work started by Start")
+	}
+	if len(p.pending) != 0 {
+		t.Errorf("expected nothing pending once started")
+	}
+}
+
+func TestStopBeforeStart(t *testing.T) {
+	_, _, p := newMockDB(t, Config{})
+	p.Stop()
+	p.Start()
+
+	if p.started || len(p.pending) != 0 {
+		t.Errorf("expected a stopped plugin not to start")
+	}
+}
+
+func TestStopWaits(t *testing.T) {
+	p, _ := newMockPrometheus(t)
+
+	var finished int32
+	p.background(func() {
+		<-p.stopping()
+		time.Sleep(20 * time.Millisecond) // completing the current cycle
+		atomic.StoreInt32(&finished, 1)
+	})
+
+	p.Stop()
+	if atomic.LoadInt32(&finished) != 1 {
+		t.Errorf("expected Stop to return once the background work returned")
+	}
+
+	p.Stop() // stopping again returns at once
+	p.background(func() { t.Errorf("expected nothing started once stopped") })
+}
+
+// descCollector describes a single descriptor, to check it's registered
+type descCollector struct{ desc *prometheus.Desc }
+
+func (c descCollector) Describe(ch chan<- *prometheus.Desc) { ch <- c.desc }
+func (c descCollector) Collect(chan<- prometheus.Metric)    {}
+
+func TestDescriptors(t *testing.T) {
+	config := Config{
+		DBName:                   "db1",
+		CallbackMetrics:          true,
+		ConnReuseRatio:           true,
+		SaturationSampleInterval: time.Second,
+		Info:                     map[string]string{"team": "orders"},
+		Registry:                 prometheus.NewRegistry(),
+		ManualStart:              true,
+	}
+	p := New(config)
+
+	before := map[string]bool{}
+	names := map[string]bool{}
+	for _, desc := range p.Descriptors() {
+		before[desc.String()] = true
+	}
+
+	db, _ := newMockGorm(t, &gorm.Config{})
+	if err := db.Use(p); err != nil {
+		t.Fatalf("failed to use the plugin, got error: %v", err)
+	}
+	t.Cleanup(p.Stop)
+
+	after := p.Descriptors()
+	if len(after) != len(before) {
+		t.Errorf("expected the %d descriptors before Initialize, got %d", len(before), len(after))
+	}
+	for _, desc := range after {
+		if !before[desc.String()] {
+			t.Errorf("descriptor %s is missing before Initialize", desc)
+		}
+		if err := config.Registry.Register(descCollector{desc}); err == nil {
+			t.Errorf("descriptor %s isn't registered by Initialize", desc)
+		}
+		names[strings.Split(strings.TrimPrefix(desc.String(), `Desc{fqName: "`), `"`)[0]] = true
+	}
+
+	mfs, err := config.Registry.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather, got error: %v", err)
+	}
+	for _, mf := range mfs {
+		if !names[mf.GetName()] {
+			t.Errorf("registered metric %s is missing from the descriptors", mf.GetName())
+		}
+	}
+	for _, name := range []string{"gorm_prometheus_build_info", "gorm_prometheus_callbacks_registered", "gorm_prometheus_collector_up", "gorm_prometheus_info", "gorm_prometheus_refresh_interval_seconds", "gorm_dbstats_saturation_ratio", "gorm_dbstats_conn_reuse_ratio"} {
+		if !names[name] {
+			t.Errorf("expected the descriptor of %s", name)
+		}
+	}
+}
+
+func TestInstanceLabel(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skipf("no hostname: %v", err)
+	}
+
+	p := New(Config{DBName: "db1", InstanceLabel: true})
+	if p.Labels["instance"] != hostname {
+		t.Errorf("expected the hostname as instance label, got %v", p.Labels)
+	}
+
+	if p := New(Config{DBName: "db1", InstanceLabel: true, Instance: "host1"}); p.Labels["instance"] != "host1" {
+		t.Errorf("expected the configured instance label, got %v", p.Labels)
+	}
+
+	if p := New(Config{DBName: "db1"}); p.Labels["instance"] != "" {
+		t.Errorf("expected no instance label, got %v", p.Labels)
+	}
+}
@@ -0,0 +1,281 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// pushState is the pusher wiring shared by the push loop and PushNow
+type pushState struct {
+	lock        sync.Mutex
+	doer        *statusDoer
+	addrs       []string
+	pushers     []*push.Pusher
+	pushStatus  prometheus.Gauge
+	pushHealthy *prometheus.GaugeVec
+}
+
+func (p *Prometheus) newPushState() *pushState {
+	client := http.DefaultClient
+	if len(p.Config.PushHeader) > 0 || p.Config.PushUserAgent != "" {
+		client = &http.Client{Transport: &headerTransport{base: http.DefaultTransport, header: p.Config.PushHeader, userAgent: p.Config.PushUserAgent}}
+	}
+	state := &pushState{doer: &statusDoer{client: client}}
+
+	state.pushStatus = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        p.metricName("prometheus", "push_status_code"),
+		Help:        p.help(p.metricName("prometheus", "push_status_code"), "HTTP status code of the last push to the Pushgateway, 0 if no response was received."),
+		ConstLabels: p.Labels,
+	})
+	state.pushHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        p.metricName("prometheus", "push_healthy"),
+		Help:        p.help(p.metricName("prometheus", "push_healthy"), "Whether the last push attempted to the Pushgateway address succeeded, 1 or 0."),
+		ConstLabels: p.Labels,
+	}, p.transformLabelNames([]string{"address"}))
+	p.register(state.pushStatus, state.pushHealthy)
+
+	// the gateways are tried in order every push, the first one is the primary
+	state.addrs = append([]string{p.PushAddr}, p.Config.PushAddrs...)
+	state.pushers = make([]*push.Pusher, len(state.addrs))
+	for i, addr := range state.addrs {
+		if p.Config.PushGatherer == nil {
+			state.pushers[i] = p.newPusher(addr, state.doer, state.pushStatus, state.pushHealthy)
+		} else { // pushed if registered to PushGatherer, e.g. from Registerers
+			state.pushers[i] = p.newPusher(addr, state.doer)
+		}
+	}
+	return state
+}
+
+func (p *Prometheus) startPush() {
+	p.every(p.Config.RefreshInterval, func() {
+		_ = p.push()
+	})
+}
+
+// PushNow pushes the metrics to the Pushgateway once, e.g. at the end of a batch job instead of the push
+// loop started by Initialize, which it can also be used with:
+//
+//	p := prometheus.New(prometheus.Config{DBName: "db1", PushAddr: "http://pushgateway:9091"})
+//	db.Use(p)
+//	defer p.PushNow()
+//
+// The pool stats are refreshed before the push.
+func (p *Prometheus) PushNow() error {
+	if p.PushAddr == "" {
+		return errors.New("gorm:prometheus PushAddr is not configured")
+	}
+
+	if p.DB != nil && p.DBStats != nil {
+		p.refresh()
+	}
+	return p.push()
+}
+
+// push pushes to the first gateway accepting the metrics, the error of the last gateway is returned
+func (p *Prometheus) push() error {
+	p.pushStateOnce.Do(func() {
+		p.pushState = p.newPushState()
+	})
+
+	state := p.pushState
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	var (
+		err  error
+		code int32
+	)
+	for i, pusher := range state.pushers {
+		atomic.StoreInt32(&state.doer.code, 0)
+		err = pusher.Push()
+		code = atomic.LoadInt32(&state.doer.code)
+		state.pushStatus.Set(float64(code))
+
+		healthy := state.pushHealthy.With(p.transformLabels(map[string]string{"address": state.addrs[i]}))
+		if err == nil {
+			healthy.Set(1)
+			break
+		}
+		healthy.Set(0)
+	}
+
+	// only the error of the last gateway is logged, once all of them failed
+	if err != nil {
+		if code == http.StatusRequestEntityTooLarge {
+			p.DB.Logger.Error(context.Background(), "gorm:prometheus push rejected with HTTP status %d, the payload exceeds the Pushgateway limit: %v", code, err)
+		} else {
+			p.DB.Logger.Error(context.Background(), "gorm:prometheus push failed with HTTP status %d: %v", code, err)
+		}
+		p.recordError("push", err)
+	}
+	return err
+}
+
+// newPusher creates the pusher of the plugin collectors, and of the extra collectors, to the Pushgateway at addr
+func (p *Prometheus) newPusher(addr string, doer push.HTTPDoer, extra ...prometheus.Collector) *push.Pusher {
+	pusher := push.New(addr, p.DBName).Client(doer)
+
+	// the Pushgateway rejects metrics labeled with a grouping label, the instance label of the metrics is carried
+	// by the grouping key of the pushes instead
+	var unlabeled bool
+	if instance := p.pushInstance(); instance != "" {
+		pusher = pusher.Grouping("instance", instance)
+		_, unlabeled = p.Labels["instance"]
+	}
+
+	if p.PushUser != "" || p.PushPassword != "" {
+		pusher.BasicAuth(p.PushUser, p.PushPassword)
+	}
+
+	if p.Config.PushGatherer != nil {
+		gatherer := p.Config.PushGatherer
+		if p.Config.PushTimestamps {
+			gatherer = &timestampGatherer{Gatherer: gatherer, at: p.lastRefresh}
+		}
+		if unlabeled {
+			gatherer = &unlabeledGatherer{Gatherer: gatherer, name: "instance"}
+		}
+		return pusher.Gatherer(gatherer)
+	}
+
+	registry := prometheus.NewRegistry()
+	for _, collector := range append(p.collectors(), extra...) {
+		if p.Config.PushTimestamps {
+			collector = &timestampCollector{Collector: collector, at: p.lastRefresh}
+		}
+		registry.MustRegister(collector)
+	}
+
+	if unlabeled {
+		return pusher.Gatherer(&unlabeledGatherer{Gatherer: registry, name: "instance"})
+	}
+	return pusher.Gatherer(registry)
+}
+
+// unlabeledGatherer removes the label name from the gathered metrics
+type unlabeledGatherer struct {
+	prometheus.Gatherer
+	name string
+}
+
+func (g *unlabeledGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			labels := metric.Label[:0]
+			for _, label := range metric.Label {
+				if label.GetName() != g.name {
+					labels = append(labels, label)
+				}
+			}
+			metric.Label = labels
+		}
+	}
+	return families, err
+}
+
+// timestampGatherer sets the timestamp of the gathered metrics to at, as timestampCollector
+type timestampGatherer struct {
+	prometheus.Gatherer
+	at func() time.Time
+}
+
+func (g *timestampGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+
+	if at := g.at(); !at.IsZero() {
+		ms := at.UnixNano() / int64(time.Millisecond)
+		for _, family := range families {
+			for _, metric := range family.Metric {
+				metric.TimestampMs = &ms
+			}
+		}
+	}
+	return families, err
+}
+
+// pushInstance returns the instance grouping the pushes, Instance, or with PushInstance the instance of Labels or
+// else the hostname. The pushes aren't grouped by instance if the hostname can't be read
+func (p *Prometheus) pushInstance() string {
+	if p.Config.Instance == "" && !p.Config.PushInstance {
+		return ""
+	}
+	if instance, ok := p.Labels["instance"]; ok { // already on the metrics, e.g. "instance" of Labels
+		return instance
+	}
+	if p.Config.Instance != "" {
+		return p.Config.Instance
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		p.DB.Logger.Error(context.Background(), "gorm:prometheus failed to get the hostname grouping the pushes, got error: %v", err)
+		return ""
+	}
+	return hostname
+}
+
+// timestampCollector sets the timestamp of the metrics of the collector to at, nothing is set before the first refresh
+type timestampCollector struct {
+	prometheus.Collector
+	at func() time.Time
+}
+
+func (c *timestampCollector) Collect(ch chan<- prometheus.Metric) {
+	at := c.at()
+	if at.IsZero() {
+		c.Collector.Collect(ch)
+		return
+	}
+
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		c.Collector.Collect(metrics)
+		close(metrics)
+	}()
+	for metric := range metrics {
+		ch <- prometheus.NewMetricWithTimestamp(at, metric)
+	}
+}
+
+// statusDoer records the HTTP status code of the last push
+type statusDoer struct {
+	client push.HTTPDoer
+	code   int32
+}
+
+func (d *statusDoer) Do(req *http.Request) (*http.Response, error) {
+	resp, err := d.client.Do(req)
+	if err == nil {
+		atomic.StoreInt32(&d.code, int32(resp.StatusCode))
+	}
+	return resp, err
+}
+
+// headerTransport adds the PushHeader and PushUserAgent to the push requests
+type headerTransport struct {
+	base      http.RoundTripper
+	header    http.Header
+	userAgent string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context()) // a RoundTripper must not modify the request
+	for name, values := range t.header {
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	if t.userAgent != "" {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	return t.base.RoundTrip(req)
+}
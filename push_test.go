@@ -0,0 +1,187 @@
+package prometheus
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type okDoer struct{}
+
+func (okDoer) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+}
+
+func TestPushGatherer(t *testing.T) {
+	var gathered int
+	push := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		gathered++
+		return nil, nil
+	})
+	p := New(Config{DBName: "db1", Registry: prometheus.NewRegistry(), PushGatherer: push})
+
+	if err := p.newPusher("http://pushgateway:9091", okDoer{}).Push(); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	if gathered != 1 {
+		t.Errorf("expected PushGatherer to be gathered once by the push, got %d", gathered)
+	}
+}
+
+// newPushgateway returns a Pushgateway answering the pushes with status, counting them in pushes
+func newPushgateway(t *testing.T, status int, pushes *int32) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(pushes, 1)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPushFailover(t *testing.T) {
+	var primaryPushes, fallbackPushes int32
+	primary := newPushgateway(t, http.StatusInternalServerError, &primaryPushes)
+	fallback := newPushgateway(t, http.StatusOK, &fallbackPushes)
+
+	p := New(Config{DBName: "db1", Registry: prometheus.NewRegistry(), PushAddr: primary.URL, PushAddrs: []string{fallback.URL}})
+	p.DB = &gorm.DB{Config: &gorm.Config{Logger: logger.Discard}}
+
+	if err := p.PushNow(); err != nil {
+		t.Fatalf("expected the push to the fallback to succeed, got %v", err)
+	}
+	if atomic.LoadInt32(&primaryPushes) != 1 || atomic.LoadInt32(&fallbackPushes) != 1 {
+		t.Errorf("expected a push to each gateway, got %d and %d", atomic.LoadInt32(&primaryPushes), atomic.LoadInt32(&fallbackPushes))
+	}
+	if v := testutil.ToFloat64(p.pushState.pushHealthy.WithLabelValues(primary.URL)); v != 0 {
+		t.Errorf("expected the primary unhealthy, got %v", v)
+	}
+	if v := testutil.ToFloat64(p.pushState.pushHealthy.WithLabelValues(fallback.URL)); v != 1 {
+		t.Errorf("expected the fallback healthy, got %v", v)
+	}
+	if v := testutil.ToFloat64(p.pushState.pushStatus); v != http.StatusOK {
+		t.Errorf("expected the status of the last push, got %v", v)
+	}
+}
+
+func TestPushFailoverAllFailed(t *testing.T) {
+	var pushes int32
+	primary := newPushgateway(t, http.StatusServiceUnavailable, &pushes)
+	fallback := newPushgateway(t, http.StatusBadGateway, &pushes)
+
+	p := New(Config{DBName: "db1", Registry: prometheus.NewRegistry(), PushAddr: primary.URL, PushAddrs: []string{fallback.URL}})
+	p.DB = &gorm.DB{Config: &gorm.Config{Logger: logger.Discard}}
+
+	if err := p.PushNow(); err == nil {
+		t.Fatalf("expected an error once every gateway failed")
+	}
+	if n := atomic.LoadInt32(&pushes); n != 2 {
+		t.Errorf("expected both gateways tried, got %d pushes", n)
+	}
+	if v := testutil.ToFloat64(p.pushState.pushStatus); v != http.StatusBadGateway {
+		t.Errorf("expected the status of the last gateway, got %v", v)
+	}
+}
+
+// recordingPushgateway returns a Pushgateway accepting the pushes, the path and body of the last one are recorded
+func recordingPushgateway(t *testing.T, path *string, body *[]byte) *httptest.Server {
+	t.Helper()
+
+	var lock sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		*path = r.URL.Path
+		*body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPushInstance(t *testing.T) {
+	var (
+		path string
+		body []byte
+	)
+	gateway := recordingPushgateway(t, &path, &body)
+
+	db, _ := newMockGorm(t, &gorm.Config{})
+	p := usePlugin(t, db, Config{DBName: "db1", Instance: "host1", PushAddr: gateway.URL})
+
+	if p.Labels["instance"] != "host1" {
+		t.Errorf("expected the metrics labeled with the instance, got %v", p.Labels)
+	}
+
+	if err := p.PushNow(); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	if path != "/metrics/job/db1/instance/host1" {
+		t.Errorf("expected the push grouped by instance, got %s", path)
+	}
+	if !bytes.Contains(body, []byte("gorm_dbstats_idle")) || bytes.Contains(body, []byte("host1")) {
+		t.Errorf("expected the pushed metrics without the instance label, got %q", body)
+	}
+}
+
+func TestPushInstanceLabels(t *testing.T) {
+	var (
+		path string
+		body []byte
+	)
+	gateway := recordingPushgateway(t, &path, &body)
+
+	db, _ := newMockGorm(t, &gorm.Config{})
+	p := usePlugin(t, db, Config{DBName: "db1", Labels: map[string]string{"instance": "127.0.0.1"}, PushInstance: true, PushAddr: gateway.URL})
+
+	// the instance of Labels groups the pushes rather than the hostname
+	if err := p.PushNow(); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	if path != "/metrics/job/db1/instance/127.0.0.1" {
+		t.Errorf("expected the push grouped by the instance label, got %s", path)
+	}
+	if bytes.Contains(body, []byte("127.0.0.1")) {
+		t.Errorf("expected the pushed metrics without the instance label, got %q", body)
+	}
+}
+
+func TestPushInstanceHostname(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skipf("no hostname: %v", err)
+	}
+
+	var (
+		path string
+		body []byte
+	)
+	gateway := recordingPushgateway(t, &path, &body)
+
+	db, _ := newMockGorm(t, &gorm.Config{})
+	p := usePlugin(t, db, Config{DBName: "db1", PushInstance: true, PushAddr: gateway.URL})
+
+	if err := p.PushNow(); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	if path != "/metrics/job/db1/instance/"+hostname {
+		t.Errorf("expected the push grouped by the hostname, got %s", path)
+	}
+	if _, ok := p.Labels["instance"]; ok {
+		t.Errorf("expected the metrics without instance label, got %v", p.Labels)
+	}
+}
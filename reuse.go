@@ -0,0 +1,70 @@
+package prometheus
+
+import (
+	"database/sql"
+	"math"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// connReuse estimates the fraction of the operations served by a connection already open. database/sql doesn't
+// count the connections it hands out, so at each refresh the connections opened since the previous refresh are
+// compared to the operations counted by the callbacks meanwhile. The connections opened are counted by Connector
+// if the DB is opened from it, otherwise they're approximated from DBStats as the growth of OpenConnections plus
+// the connections closed by the pool limits, missing the broken connections the pool discarded and replaced
+type connReuse struct {
+	ratio      prometheus.Gauge
+	operations uint64 // since the last refresh, accessed atomically
+	window     *ratioWindow
+
+	primed bool // the previous values are set, only accessed by the refresh
+	stats  sql.DBStats
+	opened float64
+}
+
+func newConnReuse(labels map[string]string, config *Config) *connReuse {
+	return &connReuse{
+		window: newRatioWindow(config),
+		ratio: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        config.metricName("dbstats", "conn_reuse_ratio"),
+			Help:        config.help(config.metricName("dbstats", "conn_reuse_ratio"), "Estimated fraction of the operations since the last refresh served by a connection already open."),
+			ConstLabels: labels,
+		}),
+	}
+}
+
+// refresh exposes the reuse over RatioWindow, since the last refresh by default, and starts a new interval, opened
+// is the Connector count of the connections opened, nil if the DB isn't opened from it
+func (r *connReuse) refresh(stats sql.DBStats, opened prometheus.Counter) {
+	operations := atomic.SwapUint64(&r.operations, 0)
+
+	var total float64
+	if opened != nil {
+		var m dto.Metric
+		if err := opened.Write(&m); err == nil {
+			total = m.GetCounter().GetValue()
+		}
+	}
+
+	previous, previousOpened := r.stats, r.opened
+	r.stats, r.opened = stats, total
+	if !r.primed {
+		r.primed = true // the operations before the first refresh can't be matched to the connections opened
+		return
+	}
+
+	var opens float64
+	if opened != nil {
+		opens = total - previousOpened
+	} else {
+		closed := (stats.MaxIdleClosed - previous.MaxIdleClosed) + (stats.MaxLifetimeClosed - previous.MaxLifetimeClosed) + (stats.MaxIdleTimeClosed - previous.MaxIdleTimeClosed)
+		opens = float64(int64(stats.OpenConnections-previous.OpenConnections) + closed)
+	}
+
+	// the fraction of the operations served by a new connection
+	if fresh, ok := r.window.add(math.Max(0, opens), float64(operations)); ok {
+		r.ratio.Set(math.Max(0, math.Min(1, 1-fresh)))
+	}
+}
@@ -0,0 +1,35 @@
+package prometheus
+
+import (
+	"database/sql"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestConnReuse(t *testing.T) {
+	r := newConnReuse(nil, &Config{})
+	r.refresh(sql.DBStats{OpenConnections: 2}, nil) // baseline
+
+	atomic.AddUint64(&r.operations, 100)
+	r.refresh(sql.DBStats{OpenConnections: 4, MaxIdleClosed: 3}, nil) // 5 connections opened
+	if v := testutil.ToFloat64(r.ratio); v != 0.95 {
+		t.Errorf("expected reuse ratio 0.95, got %v", v)
+	}
+
+	r.refresh(sql.DBStats{OpenConnections: 1, MaxIdleClosed: 3}, nil) // no operation, unchanged
+	if v := testutil.ToFloat64(r.ratio); v != 0.95 {
+		t.Errorf("expected reuse ratio unchanged, got %v", v)
+	}
+
+	opened := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_conn_reuse_opened"})
+	r.refresh(sql.DBStats{}, opened)
+	opened.Add(10)
+	atomic.AddUint64(&r.operations, 40)
+	r.refresh(sql.DBStats{}, opened)
+	if v := testutil.ToFloat64(r.ratio); v != 0.75 {
+		t.Errorf("expected reuse ratio 0.75 from the connector count, got %v", v)
+	}
+}
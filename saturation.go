@@ -0,0 +1,82 @@
+package prometheus
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// saturationSampler samples the pool more often than the refresh interval, the fraction of samples at
+// MaxOpenConnections is exposed at refresh, which is smoother than the InUse snapshot
+type saturationSampler struct {
+	ratio     prometheus.Gauge
+	noIdle    prometheus.Gauge
+	samples   uint64 // since the last refresh, accessed atomically
+	saturated uint64
+	window    *ratioWindow // only accessed by the refresh
+
+	noIdleSince time.Time // only accessed by the sampler goroutine
+}
+
+func newSaturationSampler(labels map[string]string, config *Config) *saturationSampler {
+	return &saturationSampler{
+		window: newRatioWindow(config),
+		ratio: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        config.metricName("dbstats", "saturation_ratio"),
+			Help:        config.help(config.metricName("dbstats", "saturation_ratio"), "Fraction of the samples since the last refresh where all connections allowed were in use."),
+			ConstLabels: labels,
+		}),
+		noIdle: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        config.metricName("dbstats", "no_idle_seconds"),
+			Help:        config.help(config.metricName("dbstats", "no_idle_seconds"), "Time since the pool last had an idle connection while connections were in use, 0 if it has one, in seconds."),
+			ConstLabels: labels,
+		}),
+	}
+}
+
+func (p *Prometheus) startSaturationSampler() {
+	p.loop(p.Config.SaturationSampleInterval, func() {
+		if !p.enabled() {
+			return
+		}
+
+		db, err := p.DB.DB()
+		if err != nil {
+			p.DB.Logger.Error(context.Background(), "gorm:prometheus failed to sample db status, got error: %v", err)
+			return
+		}
+
+		p.saturation.sample(db.Stats())
+	})
+}
+
+func (s *saturationSampler) sample(stats sql.DBStats) {
+	atomic.AddUint64(&s.samples, 1)
+	if stats.MaxOpenConnections > 0 && stats.InUse >= stats.MaxOpenConnections {
+		atomic.AddUint64(&s.saturated, 1)
+	}
+
+	// an unused pool has no idle connection either, it isn't undersized
+	if stats.Idle > 0 || stats.InUse == 0 {
+		s.noIdleSince = time.Time{}
+		s.noIdle.Set(0)
+		return
+	}
+
+	if s.noIdleSince.IsZero() {
+		s.noIdleSince = time.Now()
+	}
+	s.noIdle.Set(time.Since(s.noIdleSince).Seconds())
+}
+
+// refresh exposes the saturation over RatioWindow, since the last refresh by default, and starts a new interval
+func (s *saturationSampler) refresh() {
+	samples := atomic.SwapUint64(&s.samples, 0)
+	saturated := atomic.SwapUint64(&s.saturated, 0)
+	if ratio, ok := s.window.add(float64(saturated), float64(samples)); ok {
+		s.ratio.Set(ratio)
+	}
+}
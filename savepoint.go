@@ -0,0 +1,41 @@
+package prometheus
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+// savepointStatements are the savepoint statements executed by the gorm dialectors for nested transactions, by outcome
+var savepointStatements = []struct {
+	prefix  string
+	outcome string
+}{
+	{"SAVEPOINT ", "created"},
+	{"RELEASE ", "released"},
+	{"ROLLBACK TO ", "rolled_back"},
+}
+
+func newSavepoints(labels map[string]string, config *Config) *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        config.metricName("", "savepoints_total"),
+		Help:        config.help(config.metricName("", "savepoints_total"), "The total number of savepoints of nested transactions, by outcome, created, released or rolled_back."),
+		ConstLabels: labels,
+	}, config.transformLabelNames([]string{"outcome"}))
+}
+
+// countSavepoint counts the savepoint statement, gorm runs them with Exec, i.e. through the raw callbacks
+func (stats *QueryStats) countSavepoint(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+
+	sql := strings.ToUpper(strings.TrimSpace(db.Statement.SQL.String()))
+	for _, stmt := range savepointStatements {
+		if strings.HasPrefix(sql, stmt.prefix) {
+			stats.Savepoints.With(stats.config.transformLabels(map[string]string{"outcome": stmt.outcome})).Inc()
+			return
+		}
+	}
+}
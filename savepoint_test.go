@@ -0,0 +1,72 @@
+package prometheus
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils/tests"
+)
+
+// savepointDialector runs the savepoints of nested transactions with Exec, as the gorm dialectors do
+type savepointDialector struct {
+	tests.DummyDialector
+}
+
+func (savepointDialector) SavePoint(tx *gorm.DB, name string) error {
+	return tx.Exec("SAVEPOINT " + name).Error
+}
+
+func (savepointDialector) RollbackTo(tx *gorm.DB, name string) error {
+	return tx.Exec("ROLLBACK TO SAVEPOINT " + name).Error
+}
+
+func TestSavepoints(t *testing.T) {
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock, got error: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	db, err := gorm.Open(savepointDialector{}, &gorm.Config{ConnPool: sqlDB, Logger: logger.Discard, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatalf("failed to open gorm, got error: %v", err)
+	}
+	p := usePlugin(t, db, Config{CallbackMetrics: true, TransactionMetrics: true})
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SAVEPOINT").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("RELEASE SAVEPOINT").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SAVEPOINT").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Transaction(func(tx *gorm.DB) error {
+			return tx.Exec("RELEASE SAVEPOINT sp1").Error
+		}); err != nil {
+			return err
+		}
+		_ = tx.Transaction(func(*gorm.DB) error { return errors.New("rolled back") })
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("transaction failed: %v", err)
+	}
+
+	for outcome, expected := range map[string]float64{"created": 2, "released": 1, "rolled_back": 1} {
+		if v := testutil.ToFloat64(p.QueryStats.Savepoints.WithLabelValues(outcome)); v != expected {
+			t.Errorf("expected %v savepoints %s, got %v", expected, outcome, v)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+
+	if _, _, p := newMockDB(t, Config{CallbackMetrics: true}); p.QueryStats.Savepoints != nil {
+		t.Errorf("expected no savepoint metrics without TransactionMetrics")
+	}
+}
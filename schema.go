@@ -0,0 +1,60 @@
+package prometheus
+
+import (
+	"context"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+type schemaKey struct{}
+
+// WithSchema returns a context carrying the schema the statements run against, e.g. after switching it with
+// SET search_path or USE on the connection of a transaction, labeled as db_name by SchemaDBName
+func WithSchema(ctx context.Context, schema string) context.Context {
+	return context.WithValue(ctx, schemaKey{}, schema)
+}
+
+// SchemaDBName returns a DBNameFunc labeling db_name with the active schema of the statement, for a pool switching
+// schemas: the schema of the context set by WithSchema, else the schema qualifying the table, e.g. tenant1 for
+// tenant1.users. Only the given schemas are labeled, other schemas are "other", statements without schema keep
+// DBName. The schema of a connection can't be observed from the callbacks, it must be carried by the context
+//
+//	prometheus.New(prometheus.Config{DBName: "app", DBNameFunc: prometheus.SchemaDBName("tenant1", "tenant2")})
+//
+//	db.WithContext(prometheus.WithSchema(ctx, "tenant1")).Transaction(func(tx *gorm.DB) error {
+//		tx.Exec("SET search_path TO tenant1")
+//		...
+//	})
+func SchemaDBName(schemas ...string) func(*gorm.DB) string {
+	allowed := make(map[string]bool, len(schemas))
+	for _, schema := range schemas {
+		allowed[schema] = true
+	}
+
+	return func(db *gorm.DB) string {
+		var schema string
+		if ctx := db.Statement.Context; ctx != nil {
+			schema, _ = ctx.Value(schemaKey{}).(string)
+		}
+		if i := strings.LastIndexByte(db.Statement.Table, '.'); schema == "" && i > 0 {
+			schema = db.Statement.Table[:i]
+		}
+
+		switch {
+		case schema == "":
+			return ""
+		case allowed[schema]:
+			return schema
+		}
+		return "other"
+	}
+}
+
+// dbName returns the db_name label of the statement from DBNameFunc, DBName if it returns ""
+func (stats *QueryStats) dbName(db *gorm.DB) string {
+	if name := stats.config.DBNameFunc(db); name != "" {
+		return name
+	}
+	return stats.config.DBName
+}
@@ -0,0 +1,153 @@
+//go:build !gorm_prometheus_noserver
+// +build !gorm_prometheus_noserver
+
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	defaultHandlerTimeout             = 10 * time.Second // the prometheus default scrape timeout
+	defaultHandlerMaxRequestsInFlight = 10
+)
+
+// HandlerOpts configures the metrics handler, see Config.HandlerOpts
+type HandlerOpts = promhttp.HandlerOpts
+
+func defaultHandlerOpts() *HandlerOpts {
+	return &HandlerOpts{
+		Timeout:             defaultHandlerTimeout,
+		MaxRequestsInFlight: defaultHandlerMaxRequestsInFlight,
+	}
+}
+
+// Handler returns the metrics handler, the same as served by the http server, to mount it on another server.
+// It's a plain http.Handler that can be wrapped by promhttp middleware, e.g.
+//
+//	http.Handle("/metrics", promhttp.InstrumentHandlerDuration(duration.MustCurryWith(prometheus.Labels{"handler": "metrics"}), p.Handler()))
+func (p *Prometheus) Handler() http.Handler {
+	return p.handlerFor(p.Gatherer())
+}
+
+func (p *Prometheus) handlerFor(gatherer prometheus.Gatherer) http.Handler {
+	opts := *p.Config.HandlerOpts
+	if opts.ErrorLog == nil {
+		opts.ErrorLog = p.errorLog()
+	}
+	if p.Config.ExemplarFromContext != nil {
+		opts.EnableOpenMetrics = true
+	}
+
+	return p.instrumentScrape(promhttp.InstrumentMetricHandler(p.registerer(nil), promhttp.HandlerFor(gatherer, opts)))
+}
+
+// instrumentScrape counts the scrapes served by next and calls OnScrape, to notice when Prometheus stops scraping
+func (p *Prometheus) instrumentScrape(next http.Handler) http.Handler {
+	p.scrapesOnce.Do(func() {
+		p.scrapes = prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        p.metricName("prometheus", "scrape_requests_total"),
+			Help:        p.help(p.metricName("prometheus", "scrape_requests_total"), "The total number of scrapes of the metrics handlers."),
+			ConstLabels: p.Labels,
+		})
+		p.register(p.scrapes)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startedAt := time.Now()
+		next.ServeHTTP(w, r)
+
+		p.scrapes.Inc()
+		if p.Config.OnScrape != nil {
+			p.Config.OnScrape(r.RemoteAddr, time.Since(startedAt))
+		}
+	})
+}
+
+func (p *Prometheus) startServer() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", p.Handler())
+	for path, gatherer := range p.Config.Gatherers {
+		mux.Handle(path, p.handlerFor(gatherer))
+	}
+	if p.Config.DebugPath != "" {
+		mux.Handle(p.Config.DebugPath, p.debugHandler())
+	}
+	if p.Config.JSONPath != "" {
+		mux.Handle(p.Config.JSONPath, p.jsonHandler())
+	}
+
+	var handler http.Handler = mux
+	for i := len(p.Config.Middleware) - 1; i >= 0; i-- {
+		handler = p.Config.Middleware[i](handler)
+	}
+
+	server := &http.Server{
+		Addr:      fmt.Sprintf(":%d", p.Config.HTTPServerPort),
+		Handler:   handler,
+		ErrorLog:  p.errorLog(),
+		TLSConfig: p.Config.TLSConfig,
+	}
+
+	p.startLock.Lock()
+	if p.stopped {
+		p.startLock.Unlock()
+		return
+	}
+	p.shutdown = func() { _ = server.Shutdown(context.Background()) }
+	p.startLock.Unlock()
+
+	var err error
+	if server.TLSConfig != nil {
+		err = server.ListenAndServeTLS("", "") // the certificates are those of TLSConfig
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		p.DB.Logger.Error(context.Background(), "gorm:prometheus listen and serve err: %v", err)
+	}
+}
+
+// debugHandler dumps the internal state of the plugin as text, for human inspection
+func (p *Prometheus) debugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.debug.lock.Lock()
+		defer p.debug.lock.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "enabled: %t\n", p.enabled())
+		fmt.Fprintf(w, "refresh interval: %ds\n", p.Config.RefreshInterval)
+		fmt.Fprintf(w, "refreshes: %d\n", p.debug.refreshes)
+		if !p.debug.lastRefresh.IsZero() {
+			fmt.Fprintf(w, "last refresh: %s (%s ago)\n", p.debug.lastRefresh.Format(time.RFC3339), time.Since(p.debug.lastRefresh).Round(time.Second))
+		}
+		fmt.Fprintf(w, "callback metrics: %t\n", p.QueryStats != nil)
+		if p.PushAddr != "" {
+			fmt.Fprintf(w, "push address: %s\n", p.PushAddr)
+		}
+
+		fmt.Fprintf(w, "collectors:\n")
+		for _, mc := range p.MetricsCollector {
+			fmt.Fprintf(w, "  %T\n", mc)
+		}
+
+		sources := make([]string, 0, len(p.debug.errors))
+		for source := range p.debug.errors {
+			sources = append(sources, source)
+		}
+		sort.Strings(sources)
+
+		fmt.Fprintf(w, "last errors:\n")
+		for _, source := range sources {
+			e := p.debug.errors[source]
+			fmt.Fprintf(w, "  %s at %s: %s\n", source, e.at.Format(time.RFC3339), e.err)
+		}
+	})
+}
@@ -0,0 +1,18 @@
+//go:build gorm_prometheus_noserver
+// +build gorm_prometheus_noserver
+
+package prometheus
+
+import "context"
+
+// HandlerOpts is empty with the gorm_prometheus_noserver build tag, there's no metrics handler to configure
+type HandlerOpts struct{}
+
+func defaultHandlerOpts() *HandlerOpts {
+	return &HandlerOpts{}
+}
+
+// startServer is compiled out by the gorm_prometheus_noserver build tag, for push-only deployments
+func (p *Prometheus) startServer() {
+	p.DB.Logger.Error(context.Background(), "gorm:prometheus StartServer is ignored, the plugin is built with the gorm_prometheus_noserver tag")
+}
@@ -0,0 +1,38 @@
+package prometheus
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+func newSlowQueries(labels map[string]string, config *Config, labelNames []string) *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        config.metricName("", "slow_queries_total"),
+		Help:        config.help(config.metricName("", "slow_queries_total"), "The total number of operations slower than the SlowThreshold of the GORM logger."),
+		ConstLabels: labels,
+	}, labelNames)
+}
+
+// slowThreshold returns the SlowThreshold of the GORM logger, the logger created by logger.New doesn't expose its
+// config so it's read by reflection, 0 for other loggers
+func slowThreshold(logger gormlogger.Interface) time.Duration {
+	v := reflect.ValueOf(logger)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return 0
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return 0
+	}
+
+	config := v.FieldByName("Config")
+	if !config.IsValid() || config.Type() != reflect.TypeOf(gormlogger.Config{}) {
+		return 0
+	}
+	return time.Duration(config.FieldByName("SlowThreshold").Int())
+}
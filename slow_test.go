@@ -0,0 +1,20 @@
+package prometheus
+
+import (
+	"testing"
+	"time"
+
+	"gorm.io/gorm/logger"
+)
+
+func TestSlowThreshold(t *testing.T) {
+	if threshold := slowThreshold(logger.Default.LogMode(logger.Silent)); threshold != 200*time.Millisecond {
+		t.Errorf("expected the default logger threshold 200ms, got %v", threshold)
+	}
+	if threshold := slowThreshold(logger.Discard); threshold != 0 {
+		t.Errorf("expected no threshold for the discard logger, got %v", threshold)
+	}
+	if threshold := slowThreshold(nil); threshold != 0 {
+		t.Errorf("expected no threshold without logger, got %v", threshold)
+	}
+}
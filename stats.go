@@ -2,8 +2,13 @@ package prometheus
 
 import (
 	"database/sql"
-	"github.com/prometheus/client_golang/prometheus"
+	"math"
 	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 )
 
 type DBStats struct {
@@ -13,66 +18,62 @@ type DBStats struct {
 	OpenConnections prometheus.Gauge // The number of established connections both in use and idle.
 	InUse           prometheus.Gauge // The number of connections currently in use.
 	Idle            prometheus.Gauge // The number of idle connections.
+	IdleRatio       prometheus.Gauge // The fraction of the open connections that are idle, with IdleRatio.
 
-	// Counters
+	// Counters, exposed as counters with a _total suffix unless DBStatsGauges
 	WaitCount         prometheus.Gauge // The total number of connections waited for.
-	WaitDuration      prometheus.Gauge // The total time blocked waiting for a new connection.
+	WaitDuration      prometheus.Gauge // The total time blocked waiting for a new connection, in seconds, nanoseconds with DBStatsGauges.
 	MaxIdleClosed     prometheus.Gauge // The total number of connections closed due to SetMaxIdleConns.
 	MaxLifetimeClosed prometheus.Gauge // The total number of connections closed due to SetConnMaxLifetime.
 	MaxIdleTimeClosed prometheus.Gauge // The total number of connections closed due to SetConnMaxIdleTime.
+
+	waitSeconds bool // WaitDuration in seconds, unless DBStatsGauges without PresetSQLStats
 }
 
-func newStats(labels map[string]string) *DBStats {
-	stats := &DBStats{
-		MaxOpenConnections: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name:        "gorm_dbstats_max_open_connections",
-			Help:        "Maximum number of open connections to the database.",
-			ConstLabels: labels,
-		}),
-		OpenConnections: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name:        "gorm_dbstats_open_connections",
-			Help:        "The number of established connections both in use and idle.",
-			ConstLabels: labels,
-		}),
-		InUse: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name:        "gorm_dbstats_in_use",
-			Help:        "The number of connections currently in use.",
-			ConstLabels: labels,
-		}),
-		Idle: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name:        "gorm_dbstats_idle",
-			Help:        "The number of idle connections.",
-			ConstLabels: labels,
-		}),
-		WaitCount: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name:        "gorm_dbstats_wait_count",
-			Help:        "The total number of connections waited for.",
-			ConstLabels: labels,
-		}),
-		WaitDuration: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name:        "gorm_dbstats_wait_duration",
-			Help:        "The total time blocked waiting for a new connection.",
-			ConstLabels: labels,
-		}),
-		MaxIdleClosed: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name:        "gorm_dbstats_max_idle_closed",
-			Help:        "The total number of connections closed due to SetMaxIdleConns.",
-			ConstLabels: labels,
-		}),
-		MaxLifetimeClosed: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name:        "gorm_dbstats_max_lifetime_closed",
-			Help:        "The total number of connections closed due to SetConnMaxLifetime.",
-			ConstLabels: labels,
-		}),
-		MaxIdleTimeClosed: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name:        "gorm_dbstats_max_idletime_closed",
-			Help:        "The total number of connections closed due to SetConnMaxIdleTime.",
+func newStats(labels map[string]string, config *Config) *DBStats {
+
+	gauge := func(name, help string) prometheus.Gauge {
+		return prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        config.metricName("dbstats", name),
+			Help:        config.help(config.metricName("dbstats", name), help),
 			ConstLabels: labels,
-		}),
+		})
+	}
+
+	// the cumulative stats only grow while the pool is open, they're counters so rate() applies
+	counter := gauge
+	if !config.DBStatsGauges {
+		counter = func(name, help string) prometheus.Gauge {
+			name += "_total"
+			return &cumulativeCounter{statusCounter{desc: prometheus.NewDesc(config.metricName("dbstats", name), config.help(config.metricName("dbstats", name), help), nil, labels)}}
+		}
+	}
+
+	// the counter is in seconds, the former gauge in nanoseconds
+	waitDuration := counter("wait_duration_seconds", "The total time blocked waiting for a new connection, in seconds.")
+	if config.DBStatsGauges {
+		waitDurationHelp := "The total time blocked waiting for a new connection, in nanoseconds."
+		if config.Preset == PresetSQLStats {
+			waitDurationHelp = "The total time blocked waiting for a new connection, in seconds."
+		}
+		waitDuration = gauge("wait_duration", waitDurationHelp)
 	}
 
-	for _, collector := range stats.Collectors() {
-		_ = prometheus.Register(collector)
+	stats := &DBStats{
+		waitSeconds:        !config.DBStatsGauges || config.Preset == PresetSQLStats,
+		MaxOpenConnections: gauge("max_open_connections", "Maximum number of open connections to the database."),
+		OpenConnections:    gauge("open_connections", "The number of established connections both in use and idle."),
+		InUse:              gauge("in_use", "The number of connections currently in use."),
+		Idle:               gauge("idle", "The number of idle connections."),
+		WaitCount:          counter("wait_count", "The total number of connections waited for."),
+		WaitDuration:       waitDuration,
+		MaxIdleClosed:      counter("max_idle_closed", "The total number of connections closed due to SetMaxIdleConns."),
+		MaxLifetimeClosed:  counter("max_lifetime_closed", "The total number of connections closed due to SetConnMaxLifetime."),
+		MaxIdleTimeClosed:  counter("max_idletime_closed", "The total number of connections closed due to SetConnMaxIdleTime."),
+	}
+
+	if config.IdleRatio {
+		stats.IdleRatio = gauge("idle_ratio", "The fraction of the open connections that are idle, 1 while no connection is open.")
 	}
 
 	return stats
@@ -83,8 +84,20 @@ func (stats *DBStats) Set(dbStats sql.DBStats) {
 	stats.OpenConnections.Set(float64(dbStats.OpenConnections))
 	stats.InUse.Set(float64(dbStats.InUse))
 	stats.Idle.Set(float64(dbStats.Idle))
+	if stats.IdleRatio != nil {
+		// an unused pool has no idle connection either, it isn't undersized
+		ratio := 1.0
+		if dbStats.OpenConnections > 0 {
+			ratio = float64(dbStats.Idle) / float64(dbStats.OpenConnections)
+		}
+		stats.IdleRatio.Set(ratio)
+	}
 	stats.WaitCount.Set(float64(dbStats.WaitCount))
-	stats.WaitDuration.Set(float64(dbStats.WaitDuration))
+	if stats.waitSeconds {
+		stats.WaitDuration.Set(dbStats.WaitDuration.Seconds())
+	} else {
+		stats.WaitDuration.Set(float64(dbStats.WaitDuration))
+	}
 	stats.MaxIdleClosed.Set(float64(dbStats.MaxIdleClosed))
 	stats.MaxLifetimeClosed.Set(float64(dbStats.MaxLifetimeClosed))
 	stats.MaxIdleTimeClosed.Set(float64(dbStats.MaxIdleTimeClosed))
@@ -94,7 +107,40 @@ func (stats *DBStats) Set(dbStats sql.DBStats) {
 func (stats *DBStats) Collectors() (collector []prometheus.Collector) {
 	dbStatsValue := reflect.ValueOf(*stats)
 	for i := 0; i < dbStatsValue.NumField(); i++ {
-		collector = append(collector, dbStatsValue.Field(i).Interface().(prometheus.Gauge))
+		if field := dbStatsValue.Field(i); field.CanInterface() && !field.IsNil() { // optional gauges are nil
+			collector = append(collector, field.Interface().(prometheus.Gauge))
+		}
 	}
 	return
 }
+
+// cumulativeCounter exposes a cumulative sql.DBStats value as a counter, set as read from the pool like a
+// statusCounter, it implements prometheus.Gauge so the DBStats fields keep their type
+type cumulativeCounter struct {
+	statusCounter
+}
+
+// cumulative keeps the counter out of markStale, a NaN counter would break rate()
+func (c *cumulativeCounter) cumulative() {}
+
+func (c *cumulativeCounter) Desc() *prometheus.Desc {
+	return c.desc
+}
+
+func (c *cumulativeCounter) Write(m *dto.Metric) error {
+	return prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, c.value()).Write(m)
+}
+
+func (c *cumulativeCounter) Add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&c.bits)
+		if atomic.CompareAndSwapUint64(&c.bits, old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (c *cumulativeCounter) Sub(delta float64) { c.Add(-delta) }
+func (c *cumulativeCounter) Inc()              { c.Add(1) }
+func (c *cumulativeCounter) Dec()              { c.Add(-1) }
+func (c *cumulativeCounter) SetToCurrentTime() { c.Set(float64(time.Now().UnixNano()) / 1e9) }
@@ -0,0 +1,60 @@
+package prometheus
+
+import (
+	"database/sql"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestIdleRatio(t *testing.T) {
+	stats := newStats(nil, &Config{IdleRatio: true})
+	if n := len(stats.Collectors()); n != 10 {
+		t.Errorf("expected 10 collectors, got %d", n)
+	}
+
+	stats.Set(sql.DBStats{})
+	if v := testutil.ToFloat64(stats.IdleRatio); v != 1 {
+		t.Errorf("expected idle ratio 1 without open connection, got %v", v)
+	}
+
+	stats.Set(sql.DBStats{OpenConnections: 4, Idle: 1, InUse: 3})
+	if v := testutil.ToFloat64(stats.IdleRatio); v != 0.25 {
+		t.Errorf("expected idle ratio 0.25, got %v", v)
+	}
+
+	if n := len(newStats(nil, &Config{}).Collectors()); n != 9 {
+		t.Errorf("expected 9 collectors without IdleRatio, got %d", n)
+	}
+}
+
+func TestCumulativeStats(t *testing.T) {
+	stats := newStats(nil, &Config{})
+	stats.Set(sql.DBStats{WaitCount: 3, WaitDuration: 1500 * time.Millisecond})
+
+	if name := stats.WaitDuration.Desc().String(); !strings.Contains(name, `"gorm_dbstats_wait_duration_seconds_total"`) {
+		t.Errorf("expected the wait duration in seconds, got %s", name)
+	}
+	if v := testutil.ToFloat64(stats.WaitDuration); v != 1.5 {
+		t.Errorf("expected a wait duration of 1.5 seconds, got %v", v)
+	}
+
+	// the counters keep their value, only the gauges are marked stale
+	markStale(stats.Collectors())
+	if v := testutil.ToFloat64(stats.WaitCount); v != 3 {
+		t.Errorf("expected the wait count to keep its value, got %v", v)
+	}
+	if v := testutil.ToFloat64(stats.InUse); !math.IsNaN(v) {
+		t.Errorf("expected the in use gauge to be stale, got %v", v)
+	}
+
+	// the former gauge is in nanoseconds
+	stats = newStats(nil, &Config{DBStatsGauges: true})
+	stats.Set(sql.DBStats{WaitDuration: 1500 * time.Millisecond})
+	if v := testutil.ToFloat64(stats.WaitDuration); v != 1.5e9 {
+		t.Errorf("expected a wait duration of 1.5e9 nanoseconds, got %v", v)
+	}
+}
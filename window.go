@@ -0,0 +1,34 @@
+package prometheus
+
+import "time"
+
+// ratioWindow aggregates a ratio over the last refreshes, a ring buffer of the numerator and denominator of each
+// refresh interval, RatioWindow rounded up to whole refresh intervals
+type ratioWindow struct {
+	num, den []float64
+	next     int
+}
+
+func newRatioWindow(config *Config) *ratioWindow {
+	size := 1
+	if interval := time.Duration(config.RefreshInterval) * time.Second; config.RatioWindow > interval && interval > 0 {
+		size = int((config.RatioWindow + interval - 1) / interval)
+	}
+	return &ratioWindow{num: make([]float64, size), den: make([]float64, size)}
+}
+
+// add records the interval and returns the ratio over the window, false while the denominator is 0
+func (w *ratioWindow) add(num, den float64) (float64, bool) {
+	w.num[w.next], w.den[w.next] = num, den
+	w.next = (w.next + 1) % len(w.num)
+
+	var sumNum, sumDen float64
+	for i := range w.num {
+		sumNum += w.num[i]
+		sumDen += w.den[i]
+	}
+	if sumDen == 0 {
+		return 0, false
+	}
+	return sumNum / sumDen, true
+}
@@ -0,0 +1,30 @@
+package prometheus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRatioWindow(t *testing.T) {
+	w := newRatioWindow(&Config{RefreshInterval: 15, RatioWindow: 40 * time.Second}) // 3 intervals
+	for _, tt := range []struct {
+		num, den, ratio float64
+		ok              bool
+	}{
+		{0, 0, 0, false},
+		{1, 4, 0.25, true},
+		{3, 4, 0.5, true},
+		{0, 0, 0.5, true},
+		{0, 4, 0.375, true}, // the first interval left the window
+		{0, 0, 0, true},
+	} {
+		ratio, ok := w.add(tt.num, tt.den)
+		if ratio != tt.ratio || ok != tt.ok {
+			t.Errorf("add(%v, %v) expected %v, %v, got %v, %v", tt.num, tt.den, tt.ratio, tt.ok, ratio, ok)
+		}
+	}
+
+	if n := len(newRatioWindow(&Config{RefreshInterval: 15}).num); n != 1 {
+		t.Errorf("expected a window of one interval by default, got %d", n)
+	}
+}